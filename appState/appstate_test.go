@@ -1,11 +1,27 @@
 package appState
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"wget/utils"
 )
 
 func TestMirrorAsyncDownload(t *testing.T) {
-	app, err := GetAppState()
+	app, err := GetAppState(context.Background())
 	if err != nil {
 		return
 	}
@@ -15,7 +31,7 @@ func TestMirrorAsyncDownload(t *testing.T) {
 	directory := "./testdir"
 
 	// Run the function
-	err = app.mirrorAsyncDownload(outputFileName, urlStr, directory)
+	err = app.mirrorAsyncDownload(context.Background(), outputFileName, urlStr, directory)
 
 	// Check if the error is nil (indicating success)
 	if err != nil {
@@ -25,7 +41,7 @@ func TestMirrorAsyncDownload(t *testing.T) {
 
 func TestDownloadInBackground(t *testing.T) {
 	// Initialize AppState
-	app, err := GetAppState()
+	app, err := GetAppState(context.Background())
 	if err != nil {
 		return
 	}
@@ -46,7 +62,7 @@ func TestDownloadInBackground(t *testing.T) {
 
 func TestDownloadAndMirror(t *testing.T) {
 	// Setup app state
-	app, err := GetAppState()
+	app, err := GetAppState(context.Background())
 	if err != nil {
 		return
 	}
@@ -58,10 +74,1482 @@ func TestDownloadAndMirror(t *testing.T) {
 	pathRejects := "/ignore"
 
 	// Run the function
-	err = app.downloadAndMirror(url, rejectTypes, convertLink, pathRejects)
+	err = app.downloadAndMirror(context.Background(), url, rejectTypes, convertLink, pathRejects)
 
 	// Check if the error is nil (indicating success)
 	if err != nil {
 		t.Fatalf("Expected no error, but got: %v", err)
 	}
 }
+
+func TestAsyncDownloadResume(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	// Leave a partial file behind as if a previous run was interrupted
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), []byte(content[:4096]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The checksum must also cover the bytes that were already on disk
+	checksum, err := utils.ParseChecksum(fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.urlArgs.continueDownload = true
+	if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/data.bin", "", dir, checksum); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Fatalf("Expected %d resumed bytes, but got %d", len(content), len(got))
+	}
+}
+
+func TestFailedResumeKeepsFinalFile(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var fail string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch fail {
+		case "status":
+			w.WriteHeader(http.StatusNotFound)
+		case "body":
+			// Resume as asked, then drop the connection
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 4096-%d/%d", len(content)-1, len(content)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)-4096))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(content[4096:5000]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	final := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(final, []byte(content[:4096]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.retry = utils.RetryPolicy{Tries: 1}
+	app.urlArgs.continueDownload = true
+	for _, fail = range []string{"status", "body"} {
+		if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/data.bin", "", dir, nil); err == nil {
+			t.Fatalf("Expected the %s failure to fail the download", fail)
+		}
+		got, err := os.ReadFile(final)
+		if err != nil || !strings.HasPrefix(content, string(got)) || len(got) < 4096 {
+			t.Fatalf("Expected the file to stay at its name after a %s failure, but got %d bytes: %v", fail, len(got), err)
+		}
+		if utils.FileExists(final + ".part") {
+			t.Fatalf("Expected no part file after a %s failure", fail)
+		}
+	}
+}
+
+func TestSegmentedDownloader(t *testing.T) {
+	content := strings.Repeat("abcdefghij", 10007)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	app := newAppstate()
+	if err := app.segmentedDownloader(context.Background(), "", server.URL+"/data.bin", "", dir, 4, nil); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Fatalf("Segmented download does not match the original content")
+	}
+}
+
+func TestSegmentedDownloaderTries(t *testing.T) {
+	content := strings.Repeat("abcdefghij", 1000)
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+			return
+		}
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/busy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	// --tries counts every request of a segment, and a fatal status is
+	// never repeated
+	for path, want := range map[string]int{"/busy": 6, "/denied": 2} {
+		app := newAppstate()
+		app.retry = utils.RetryPolicy{Tries: 3}
+		if err := app.segmentedDownloader(context.Background(), "", server.URL+path, "", t.TempDir(), 2, nil); err == nil {
+			t.Fatalf("Expected %s to fail", path)
+		}
+		if requests[path] != want {
+			t.Errorf("Expected %d range requests to %s, but got %d", want, path, requests[path])
+		}
+	}
+}
+
+func TestAsyncDownloadRetriesAndResumes(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// Drop the connection half way through the body
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write([]byte(content[:5000]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		default:
+			http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	app := newAppstate()
+	app.retry = utils.RetryPolicy{Tries: 3}
+	if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/data.bin", "", dir, nil); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content || requests != 3 {
+		t.Fatalf("Expected %d bytes in 3 requests, but got %d bytes in %d requests", len(content), len(got), requests)
+	}
+}
+
+func TestResumeIgnoredRangeThenDropped(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// Ignore the range and drop the connection part way through
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write([]byte(content[:5000]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.bin.part"), []byte(content[:4096]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.retry = utils.RetryPolicy{Tries: 2}
+	app.urlArgs.continueDownload = true
+	if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/data.bin", "", dir, nil); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Fatalf("Expected the full %d bytes, but got %d", len(content), len(got))
+	}
+}
+
+func TestReadTimeoutAndProxy(t *testing.T) {
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10000")
+		w.Write([]byte(strings.Repeat("x", 5000)))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // send nothing more until the client gives up
+	}))
+	defer stalled.Close()
+
+	dir := t.TempDir()
+	app := newAppstate()
+	app.retry = utils.RetryPolicy{Tries: 1}
+	app.urlArgs.readTimeout = "0.2"
+	if err := app.buildHttpClient(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := app.AsyncDownload(context.Background(), "", stalled.URL+"/data.bin", "", dir, nil); err == nil {
+		t.Fatal("Expected the stalled download to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected --read-timeout to end the download, but it took %s", elapsed)
+	}
+
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	app = newAppstate()
+	app.urlArgs.proxy = proxy.URL
+	if err := app.buildHttpClient(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.AsyncDownload(context.Background(), "", "http://example.invalid/file.txt", "", dir, nil); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if proxied != "http://example.invalid/file.txt" {
+		t.Fatalf("Expected the request to go through the proxy, but it saw %q", proxied)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "file.txt")); string(got) != "via proxy" {
+		t.Fatalf("Expected the proxy's response to be saved, but got %q", got)
+	}
+}
+
+func TestRequestOptionsAreSent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != "POST" || string(body) != "a=1" ||
+			r.Header.Get("X-Token") != "secret" || r.UserAgent() != "build-bot/2" ||
+			r.Referer() != "https://ci.example.com/" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	app := newAppstate()
+	if err := app.urlArgs.request.AddHeader("X-Token: secret"); err != nil {
+		t.Fatal(err)
+	}
+	app.urlArgs.request.UserAgent = "build-bot/2"
+	app.urlArgs.request.Referer = "https://ci.example.com/"
+	app.urlArgs.request.Body = []byte("a=1")
+
+	if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/submit", "", t.TempDir(), nil); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+}
+
+func TestCredentialsOnlyForOriginHost(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrc, []byte("machine docs.internal login reader password hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", netrc)
+
+	app := newAppstate()
+	app.authorizeHost("https://docs.internal/guide/index.html")
+
+	opts := app.requestOptions("https://docs.internal/guide/style.css")
+	if opts.Credentials.User != "reader" || opts.Credentials.Password != "hunter2" {
+		t.Fatalf("Expected netrc credentials for the origin host, but got: %+v", opts.Credentials)
+	}
+	if opts := app.requestOptions("https://cdn.example.com/lib.js"); !opts.Credentials.Empty() {
+		t.Fatalf("Expected no credentials for a discovered host, but got: %+v", opts.Credentials)
+	}
+}
+
+func TestCookiesPersistAcrossRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			w.Write([]byte("welcome"))
+			return
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	app := newAppstate()
+	if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/login", "", dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/report", "", dir, nil); err != nil {
+		t.Fatalf("Expected the session cookie to be sent, but got: %v", err)
+	}
+
+	cookieFile := filepath.Join(dir, "cookies.txt")
+	if err := app.cookies.Save(cookieFile, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh run that loads the saved jar is already logged in
+	next := newAppstate()
+	if err := next.cookies.Load(cookieFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := next.AsyncDownload(context.Background(), "", server.URL+"/report", "", dir, nil); err != nil {
+		t.Fatalf("Expected the loaded cookie to be sent, but got: %v", err)
+	}
+}
+
+func TestBackgroundDownloadLeavesCookiesToChild(t *testing.T) {
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	cookieFile := filepath.Join(dir, "cookies.txt")
+	jar := "# Netscape HTTP Cookie File\nexample.com\tFALSE\t/\tFALSE\t0\tsession\tabc\n"
+	if err := os.WriteFile(cookieFile, []byte(jar), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.urlArgs.url = "http://example.com/file.txt"
+	app.urlArgs.workInBackground = true
+	app.urlArgs.loadCookies = cookieFile
+	app.urlArgs.saveCookies = cookieFile
+	if err := app.taskManager(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(cookieFile); string(got) != jar {
+		t.Fatalf("Expected the background process to be left to save the cookies, but the file now holds:\n%s", got)
+	}
+}
+
+func TestChecksumMismatchQuarantinesFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered"))
+	}))
+	defer server.Close()
+
+	checksum, err := utils.ParseChecksum(fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("original"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	app := newAppstate()
+	_, err = app.AsyncDownload(context.Background(), "", server.URL+"/release.tar", "", dir, checksum)
+	if !errors.Is(err, utils.ErrChecksumMismatch) {
+		t.Fatalf("Expected a checksum mismatch, but got: %v", err)
+	}
+	if utils.FileExists(filepath.Join(dir, "release.tar")) || !utils.FileExists(filepath.Join(dir, "release.tar.corrupt")) {
+		t.Fatalf("Expected the corrupt download to be moved aside")
+	}
+}
+
+func TestInterruptedDownloadKeepsPartFile(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write([]byte(content[:5000]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	final := filepath.Join(dir, "data.bin")
+	app := newAppstate()
+	app.retry = utils.RetryPolicy{Tries: 1}
+	if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/data.bin", "", dir, nil); err == nil {
+		t.Fatal("Expected the dropped connection to fail the download")
+	}
+	if utils.FileExists(final) {
+		t.Fatal("Expected no file under the final name after an interrupted download")
+	}
+	if info, err := os.Stat(final + ".part"); err != nil || info.Size() != 5000 {
+		t.Fatalf("Expected a 5000 byte part file, but got: %v %v", info, err)
+	}
+
+	app.urlArgs.continueDownload = true
+	if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/data.bin", "", dir, nil); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	got, err := os.ReadFile(final)
+	if err != nil || string(got) != content || utils.FileExists(final+".part") {
+		t.Fatalf("Expected the part file to be completed and renamed, but got %d bytes: %v", len(got), err)
+	}
+}
+
+func TestCancelledDownloadIsResumable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10000")
+		w.Write([]byte(strings.Repeat("x", 5000)))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // stall until the client gives up
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	dir := t.TempDir()
+	app := newAppstate()
+	if _, err := app.AsyncDownload(ctx, "", server.URL+"/data.bin", "", dir, nil); err == nil {
+		t.Fatal("Expected the cancelled download to fail")
+	}
+	if utils.FileExists(filepath.Join(dir, "data.bin")) || !utils.FileExists(filepath.Join(dir, "data.bin.part")) {
+		t.Fatal("Expected only the part file to remain after cancellation")
+	}
+	if len(app.summary.partial) != 1 {
+		t.Fatalf("Expected the part file in the run summary, but got: %v", app.summary.partial)
+	}
+}
+
+func TestDownloadMultipleFilesBoundsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, peak int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	var list strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&list, "%s/file%d\n", server.URL, i)
+	}
+	listFile := filepath.Join(dir, "links.txt")
+	if err := os.WriteFile(listFile, []byte(list.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.urlArgs.jobs = 4
+	app.urlArgs.maxPerHost = 2
+	if err := app.downloadMultipleFiles(context.Background(), listFile, "", dir); err != nil {
+		t.Fatal(err)
+	}
+	if peak > 2 {
+		t.Fatalf("Expected at most 2 requests to one host at a time, but saw %d", peak)
+	}
+	for i := 0; i < 20; i++ {
+		if !utils.FileExists(filepath.Join(dir, fmt.Sprintf("file%d", i))) {
+			t.Fatalf("Expected file%d to be downloaded", i)
+		}
+	}
+}
+
+func TestDownloadMultipleFilesBusyHostDoesNotHoldWorkers(t *testing.T) {
+	otherStarted := make(chan struct{})
+	var once sync.Once
+	var mu sync.Mutex
+	var first, overlapped bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Host, "localhost") {
+			once.Do(func() { close(otherStarted) })
+		} else {
+			mu.Lock()
+			wait := !first
+			first = true
+			mu.Unlock()
+			// Hold the first download from the capped host until the other
+			// host has started
+			if wait {
+				select {
+				case <-otherStarted:
+					mu.Lock()
+					overlapped = true
+					mu.Unlock()
+				case <-time.After(2 * time.Second):
+				}
+			}
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	other := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	var list strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&list, "%s/a%d\n", server.URL, i)
+	}
+	for i := 0; i < 2; i++ {
+		fmt.Fprintf(&list, "%s/b%d\n", other, i)
+	}
+	listFile := filepath.Join(dir, "links.txt")
+	if err := os.WriteFile(listFile, []byte(list.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.urlArgs.jobs = 5
+	app.urlArgs.maxPerHost = 1
+	if err := app.downloadMultipleFiles(context.Background(), listFile, "", dir); err != nil {
+		t.Fatal(err)
+	}
+	if !overlapped {
+		t.Fatal("Expected the second host to start while the first was at its limit")
+	}
+	for _, name := range []string{"a0", "a9", "b0", "b1"} {
+		if !utils.FileExists(filepath.Join(dir, name)) {
+			t.Fatalf("Expected %s to be downloaded", name)
+		}
+	}
+}
+
+func TestDownloadMultipleFilesReportsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/private":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	list := fmt.Sprintf("%s/ok\n%s/missing\n%s/private\n", server.URL, server.URL, server.URL)
+	listFile := filepath.Join(dir, "links.txt")
+	if err := os.WriteFile(listFile, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.retry = utils.RetryPolicy{Tries: 1}
+	app.urlArgs.failedList = filepath.Join(dir, "failed.txt")
+	err := app.downloadMultipleFiles(context.Background(), listFile, "", dir)
+	// Authentication failures (6) take precedence over server errors (8)
+	if code := utils.ExitCode(err); code != utils.ExitAuth {
+		t.Fatalf("Expected exit code %d, but got %d (%v)", utils.ExitAuth, code, err)
+	}
+
+	failed, err := os.ReadFile(app.urlArgs.failedList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(failed))
+	if len(lines) != 2 || strings.Contains(string(failed), server.URL+"/ok") {
+		t.Fatalf("Expected the two failed URLs in the failed list, but got:\n%s", failed)
+	}
+}
+
+func TestDownloadMultipleFilesInterruptedListsRemaining(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel() // Ctrl-C while the first download is running
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	var list strings.Builder
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&list, "%s/file%d\n", server.URL, i)
+	}
+	listFile := filepath.Join(dir, "links.txt")
+	if err := os.WriteFile(listFile, []byte(list.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.urlArgs.jobs = 1
+	app.urlArgs.failedList = filepath.Join(dir, "failed.txt")
+	if err := app.downloadMultipleFiles(ctx, listFile, "", dir); err == nil {
+		t.Fatal("Expected the interrupted run to fail")
+	}
+
+	failed, err := os.ReadFile(app.urlArgs.failedList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(failed)); len(got) != 5 {
+		t.Fatalf("Expected every entry in the failed list, but got:\n%s", failed)
+	}
+}
+
+func TestInputListWithoutURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "links.txt")
+	if err := os.WriteFile(listFile, []byte(server.URL+"/first\n"+server.URL+"/second\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"wget", "-i=-"}
+	if err := newAppstate().parseArgs(); err != nil {
+		t.Fatalf("Expected -i=- to need no URL, but got: %v", err)
+	}
+
+	os.Args = []string{"wget", "-P=" + dir, "-i=" + listFile}
+	app := newAppstate()
+	if err := app.taskManager(context.Background(), app.parseArgs()); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	for _, name := range []string{"first", "second"} {
+		if !utils.FileExists(filepath.Join(dir, name)) {
+			t.Fatalf("Expected %s to be downloaded", name)
+		}
+	}
+}
+
+func TestRateLimitValues(t *testing.T) {
+	for limit, valid := range map[string]bool{"400k": true, "2M": true, "500m": true, "fastk": false, "0k": false, "500": false, "k": false} {
+		list := newInputList(strings.NewReader("http://example.com/file\n  rate-limit=" + limit + "\n"))
+		if _, err := list.next(); (err == nil) != valid {
+			t.Errorf("Expected rate-limit=%s valid to be %v, but got: %v", limit, valid, err)
+		}
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"wget", "--rate-limit=0k", "http://example.com/file"}
+	if err := newAppstate().parseArgs(); err == nil {
+		t.Error("Expected --rate-limit=0k to be rejected")
+	}
+}
+
+func TestDownloadMultipleFilesEntryOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private" && r.Header.Get("X-Token") != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	list := fmt.Sprintf(`# release files
+%[1]s/report
+  out=first.txt
+%[1]s/report
+  out=second.txt
+  dir=archive
+
+%[1]s/private
+  header=X-Token: abc
+%[1]s/broken
+  colour=blue
+`, server.URL)
+	listFile := filepath.Join(dir, "links.txt")
+	if err := os.WriteFile(listFile, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	err := app.downloadMultipleFiles(context.Background(), listFile, "", dir)
+	// Only the entry with the unknown option fails
+	if code := utils.ExitCode(err); code != utils.ExitParse {
+		t.Fatalf("Expected exit code %d, but got %d (%v)", utils.ExitParse, code, err)
+	}
+	for _, name := range []string{"first.txt", filepath.Join("archive", "second.txt"), "private"} {
+		if !utils.FileExists(filepath.Join(dir, name)) {
+			t.Fatalf("Expected %s to be downloaded", name)
+		}
+	}
+	if utils.FileExists(filepath.Join(dir, "broken")) {
+		t.Fatalf("Expected the entry with an unknown option to be skipped")
+	}
+}
+
+func TestRobotsRulesGateMirrorLinks(t *testing.T) {
+	robots := `User-agent: *
+Disallow: /
+
+# Rules for us replace the catch-all group
+User-agent: Wget
+Disallow: /private/
+Disallow: /*.pdf$
+Allow: /private/press/
+Crawl-delay: 0.05
+Sitemap: https://example.com/sitemap.xml
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte(robots))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	app := newAppstate()
+	cases := map[string]bool{
+		"/index.html":                true,
+		"/private/notes.html":        false,
+		"/private/press/launch.html": true,
+		"/docs/manual.pdf":           false,
+		"/docs/manual.pdf?v=2":       true,
+	}
+	for path, want := range cases {
+		if got := app.robotsAllowed(context.Background(), server.URL+path); got != want {
+			t.Errorf("robotsAllowed(%s) = %v, want %v", path, got, want)
+		}
+	}
+
+	// Requests to the host are spaced out by the Crawl-delay
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := app.politeWait(context.Background(), server.URL+"/index.html"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("Expected the crawl delay to space out requests, but 3 took %s", elapsed)
+	}
+
+	app.urlArgs.robotsOff = true
+	if !app.robotsAllowed(context.Background(), server.URL+"/private/notes.html") {
+		t.Fatalf("Expected --robots=off to allow every URL")
+	}
+}
+
+func TestCrawlLimits(t *testing.T) {
+	var limits CrawlLimits
+	limits.start("https://example.com/docs/guide/intro.html", UrlArgs{level: 2, quota: 1000, maxPages: 2, noParent: true})
+
+	if ok, _ := limits.admit("https://example.com/docs/guide/setup.html", 2); !ok {
+		t.Fatalf("Expected a link within --level and the start directory to be admitted")
+	}
+	if ok, _ := limits.admit("https://example.com/docs/guide/setup.html", 3); ok {
+		t.Fatalf("Expected a link deeper than --level to be refused")
+	}
+	if ok, _ := limits.admit("https://example.com/docs/index.html", 1); ok {
+		t.Fatalf("Expected a link above the start directory to be refused with --no-parent")
+	}
+
+	if !limits.takePage() || !limits.takePage() || limits.takePage() {
+		t.Fatalf("Expected exactly 2 pages with --max-pages=2")
+	}
+	limits.addBytes(1000)
+	if limits.quotaLeft() {
+		t.Fatalf("Expected no new downloads once the quota is used up")
+	}
+}
+
+func TestMirrorCrawlsBreadthFirst(t *testing.T) {
+	site := map[string]string{
+		"/":            `<a href="/a.html">a</a><a href="/b.html">b</a><img src="/logo.png">`,
+		"/a.html":      `<a href="/">home</a><a href="/deep/c.html">c</a><div style="background: url('/bg.png')"></div>`,
+		"/b.html":      `<a href="/a.html">a</a><a href="/b.html">self</a>`,
+		"/deep/c.html": `<a href="/deep/d.html">d</a>`,
+		"/deep/d.html": `too deep`,
+		"/logo.png":    "png",
+		"/bg.png":      "png",
+	}
+	var mu sync.Mutex
+	var order []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := site[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		order = append(order, r.URL.Path)
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, ".png") {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	// Mirrored files are saved below the current directory
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	app := newAppstate()
+	app.urlArgs.level = 2
+	if err := app.downloadAndMirror(context.Background(), server.URL+"/", "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every page is fetched once and depth 1 is done before depth 2 starts
+	count := make(map[string]int)
+	depth2 := -1
+	for i, path := range order {
+		count[path]++
+		if path == "/deep/c.html" && depth2 < 0 {
+			depth2 = i
+		}
+	}
+	for _, path := range []string{"/", "/a.html", "/b.html", "/logo.png", "/deep/c.html", "/bg.png"} {
+		if count[path] != 1 {
+			t.Fatalf("Expected %s to be fetched once, but got %d (order %v)", path, count[path], order)
+		}
+	}
+	if count["/deep/d.html"] != 0 {
+		t.Fatalf("Expected pages beyond --level=2 to be skipped, got order %v", order)
+	}
+	for _, path := range order[depth2:] {
+		if path == "/a.html" || path == "/b.html" || path == "/logo.png" {
+			t.Fatalf("Expected breadth-first order, got %v", order)
+		}
+	}
+
+	host, _ := utils.ExtractDomain(server.URL)
+	for _, file := range []string{"index.html", "a.html", "logo.png", filepath.Join("deep", "c.html")} {
+		if !utils.FileExists(filepath.Join(dir, host, file)) {
+			t.Fatalf("Expected %s to be mirrored", file)
+		}
+	}
+}
+
+func TestMirrorFollowsStylesheetAndMediaLinks(t *testing.T) {
+	var mu sync.Mutex
+	fetched := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched[r.URL.Path] = true
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<link rel="stylesheet" href="/css/site.css">
+<link rel="icon" href="/favicon.ico">
+<img src="/small.png" srcset="/medium.png 2x, /large.png 3x" sizes="50vw">
+<picture><source srcset="/wide.webp 800w"></picture>
+<video src="/clip.mp4" poster="/poster.jpg"></video>
+<object data="/doc.svg"></object>
+<iframe src="/frame.html"></iframe>
+<meta http-equiv="refresh" content="0; url=/moved.html">
+<a href="mailto:someone@example.com">mail</a>
+<img src="data:image/png;base64,AAAA">`))
+		case "/css/site.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`@import "/css/theme.css";
+/* url(/commented.png) */
+@font-face { src: url('/fonts/a.woff2') format("woff2"); }`))
+		case "/css/theme.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`body { background: url(/bg.png) }`))
+		case "/frame.html", "/moved.html":
+			w.Header().Set("Content-Type", "text/html")
+		case "/robots.txt":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+	}))
+	defer server.Close()
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	app := newAppstate()
+	app.urlArgs.level = 1
+	app.urlArgs.pageRequisites = true
+	if err := app.downloadAndMirror(context.Background(), server.URL+"/", "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		"/css/site.css", "/css/theme.css", "/fonts/a.woff2", "/bg.png", "/favicon.ico",
+		"/small.png", "/medium.png", "/large.png", "/wide.webp", "/clip.mp4",
+		"/poster.jpg", "/doc.svg", "/frame.html", "/moved.html",
+	} {
+		if !fetched[path] {
+			t.Errorf("Expected %s to be fetched, got %v", path, fetched)
+		}
+	}
+	if fetched["/commented.png"] {
+		t.Errorf("Expected links in CSS comments to be ignored")
+	}
+}
+
+func TestMirrorResolvesAndCanonicalisesLinks(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		hits[r.URL.RequestURI()]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/docs/guide/index.html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<a href="intro.html">intro</a>
+<a href="./intro.html#top">again</a>
+<a href="../%%7Eapi/ref.html?utm_source=feed&page=2">ref</a>
+<a href="HTTP://%s/docs/guide/intro.html">absolute</a>
+<a href="sub/">section</a>`, strings.ToUpper(strings.TrimPrefix(r.Host, "http://")))
+		case "/docs/guide/sub/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<base href="/static/v2/"><img src="logo.png">`))
+		case "/docs/guide/intro.html", "/docs/~api/ref.html":
+			w.Header().Set("Content-Type", "text/html")
+		default:
+			w.Header().Set("Content-Type", "image/png")
+		}
+	}))
+	defer server.Close()
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	app := newAppstate()
+	app.urlArgs.stripParams = "utm_*"
+	if err := app.downloadAndMirror(context.Background(), server.URL+"/docs/guide/index.html", "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{
+		"/docs/guide/index.html":     1,
+		"/docs/guide/intro.html":     1,
+		"/docs/~api/ref.html?page=2": 1,
+		"/docs/guide/sub/":           1,
+		"/static/v2/logo.png":        1,
+	}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Fatalf("Expected %v, got %v", expected, hits)
+	}
+}
+
+func TestMirrorConvertLinks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/docs/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<link rel="stylesheet" href="css/site.css">`+
+				`<a href="http://%s/about.html#team">about</a>`+
+				`<a href="guide.pdf">guide</a>`+
+				`<img src="/img/a.png" srcset="/img/a.png 1x, /img/b.png 2x">`, r.Host)
+		case "/docs/css/site.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`body { background: url("../../img/a.png") }`))
+		case "/about.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<base href="/docs/"><a href="./">docs</a>`))
+		case "/robots.txt":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+	}))
+	defer server.Close()
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	app := newAppstate()
+	if err := app.downloadAndMirror(context.Background(), server.URL+"/docs/", "pdf", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(filepath.Join("127.0.0.1", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// Saved files are linked relative to the page, the rejected PDF by its
+	// remote URL
+	index := read("docs/index.html")
+	for _, want := range []string{
+		`href="css/site.css"`,
+		`href="../about.html#team"`,
+		fmt.Sprintf(`href="%s/docs/guide.pdf"`, server.URL),
+		`src="../img/a.png"`,
+		`srcset="../img/a.png 1x, ../img/b.png 2x"`,
+	} {
+		if !strings.Contains(index, want) {
+			t.Errorf("Expected index.html to contain %s, got %s", want, index)
+		}
+	}
+	if css := read("docs/css/site.css"); css != `body { background: url("../../img/a.png") }` {
+		t.Errorf("Expected the stylesheet link to point at the local image, got %s", css)
+	}
+	// Links resolve against <base href>, which is then removed
+	if about := read("about.html"); !strings.Contains(about, `<a href="docs/index.html">`) || strings.Contains(about, "/docs/\"") {
+		t.Errorf("Expected about.html to link to docs/index.html without a base, got %s", about)
+	}
+}
+
+func TestConvertMirrorDirKeepsOriginals(t *testing.T) {
+	const page = `<a href="/docs/">docs</a><a href="/missing.html">gone</a>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+		case "/docs/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="../">home</a>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := newAppstate().downloadAndMirror(context.Background(), server.URL+"/", "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Converting twice gives the same result since the second run starts
+	// from the backups the first one kept
+	index := filepath.Join("127.0.0.1", "index.html")
+	var converted string
+	for run := 0; run < 2; run++ {
+		app := newAppstate()
+		app.urlArgs.backupConverted = true
+		if err := app.convertMirrorDir("."); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run == 1 && string(data) != converted {
+			t.Fatalf("Expected converting again to change nothing, got %s", data)
+		}
+		converted = string(data)
+	}
+	if !strings.Contains(converted, `href="docs/index.html"`) || !strings.Contains(converted, `href="http://127.0.0.1/missing.html"`) {
+		t.Errorf("Expected links converted from the saved paths, got %s", converted)
+	}
+	if orig, err := os.ReadFile(index + ".orig"); err != nil || string(orig) != page {
+		t.Errorf("Expected the original page to be kept, got %q, %v", orig, err)
+	}
+	if docs, _ := os.ReadFile(filepath.Join("127.0.0.1", "docs", "index.html")); !strings.Contains(string(docs), `href="../index.html"`) {
+		t.Errorf("Expected the subdirectory page to link back up, got %s", docs)
+	}
+}
+
+func TestMirrorAdjustExtension(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<link rel="stylesheet" href="style.php?v=2"><a href="list?id=3">3</a><a href="list?id=4">4</a>`))
+		case "/style.php":
+			w.Header().Set("Content-Type", "text/css; charset=utf-8")
+			w.Write([]byte(`body { color: red }`))
+		case "/list":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<a href="/">home</a>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	app := newAppstate()
+	app.urlArgs.adjustExtension = true
+	app.urlArgs.backupConverted = true
+	if err := app.downloadAndMirror(context.Background(), server.URL+"/", "", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"index.html", "style.php@v=2.css", "list@id=3.html", "list@id=4.html"} {
+		if !utils.FileExists(filepath.Join("127.0.0.1", file)) {
+			t.Errorf("Expected %s to be saved", file)
+		}
+	}
+	read := func(file string) string {
+		data, _ := os.ReadFile(filepath.Join("127.0.0.1", file))
+		return string(data)
+	}
+	index := read("index.html")
+	for _, want := range []string{`href="style.php@v=2.css"`, `href="list@id=3.html"`, `href="list@id=4.html"`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("Expected index.html to contain %s, got %s", want, index)
+		}
+	}
+
+	// The convert-links subcommand reads the same URLs back from the names
+	list := read("list@id=3.html")
+	if err := newAppstate().convertMirrorDir("."); err != nil {
+		t.Fatal(err)
+	}
+	if read("index.html") != index || read("list@id=3.html") != list {
+		t.Errorf("Expected converting the saved mirror to give the same links, got %s", read("index.html"))
+	}
+}
+
+func TestDownloadFileNames(t *testing.T) {
+	var heads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Like many presigned URLs, only GET is allowed
+		if r.Method == http.MethodHead {
+			heads++
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Query().Get("id") {
+		case "42", "45":
+			w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`)
+		case "43":
+			w.Header().Set("Content-Disposition", `attachment; filename="../../escape.sh"`)
+		}
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	app := newAppstate()
+	app.urlArgs.contentDisposition = true
+	for _, url := range []string{"/download?id=42", "/download?id=43", "/download?id=44", "/files/"} {
+		if _, err := app.AsyncDownload(context.Background(), "", server.URL+url, "", dir, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The server's name wins, made safe; otherwise the query is kept in the
+	// name and a directory URL is saved as index.html
+	for _, file := range []string{"résumé.pdf", "escape.sh", "download@id=44", "index.html"} {
+		if !utils.FileExists(filepath.Join(dir, file)) {
+			t.Errorf("Expected %s to be saved", file)
+		}
+	}
+	// The name is read from the download itself; only --continue asks first
+	if heads != 0 {
+		t.Errorf("Expected no HEAD requests without --continue, but got %d", heads)
+	}
+	resumed := t.TempDir()
+	app.urlArgs.continueDownload = true
+	if err := app.singleDownloader(context.Background(), "", server.URL+"/download?id=45", "", resumed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if heads != 1 || !utils.FileExists(filepath.Join(resumed, "résumé.pdf")) {
+		t.Errorf("Expected a rejected HEAD to fall back to the downloaded name")
+	}
+	if utils.FileExists(filepath.Join(filepath.Dir(filepath.Dir(dir)), "escape.sh")) {
+		t.Errorf("Expected the suggested name not to leave the directory")
+	}
+}
+
+func TestClobberPolicies(t *testing.T) {
+	var mu sync.Mutex
+	content, modified := "v1", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		http.ServeContent(w, r, "file.txt", modified, strings.NewReader(content))
+	}))
+	defer server.Close()
+	publish := func(body string, at time.Time) {
+		mu.Lock()
+		content, modified = body, at
+		mu.Unlock()
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	download := func(app *AppState, dir string) {
+		if _, err := app.AsyncDownload(context.Background(), "", server.URL+"/file.txt", "", dir, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// By default a second download is saved beside the first
+	dir := t.TempDir()
+	download(newAppstate(), dir)
+	download(newAppstate(), dir)
+	if !utils.FileExists(filepath.Join(dir, "file.txt.1")) {
+		t.Fatalf("Expected the second download to be saved as file.txt.1")
+	}
+
+	// A name given with -O or out= is overwritten instead
+	named := t.TempDir()
+	for _, version := range []string{"o1", "o2"} {
+		publish(version, modified)
+		if err := newAppstate().singleDownloader(context.Background(), "out.bin", server.URL+"/file.txt", "", named, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newAppstate().AsyncDownload(context.Background(), "entry.bin", server.URL+"/file.txt", "", named, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if read(filepath.Join(named, "out.bin")) != "o2" || read(filepath.Join(named, "entry.bin")) != "o2" ||
+		utils.FileExists(filepath.Join(named, "out.bin.1")) || utils.FileExists(filepath.Join(named, "entry.bin.1")) {
+		t.Fatalf("Expected a repeated -O or out= download to overwrite the file")
+	}
+	publish("v1", modified)
+
+	// --no-clobber does not even ask the server
+	app := newAppstate()
+	app.urlArgs.noClobber = true
+	before := requests
+	download(app, dir)
+	if requests != before || utils.FileExists(filepath.Join(dir, "file.txt.2")) {
+		t.Fatalf("Expected --no-clobber to skip the download")
+	}
+
+	// -N keeps the copy until the server has a newer one, and gives the
+	// file the server's time
+	app = newAppstate()
+	app.urlArgs.timestamping = true
+	download(app, dir)
+	if utils.FileExists(filepath.Join(dir, "file.txt.2")) || read(filepath.Join(dir, "file.txt")) != "v1" {
+		t.Fatalf("Expected -N to keep the unchanged file")
+	}
+	newer := modified.Add(time.Hour)
+	publish("v2", newer)
+	download(app, dir)
+	info, err := os.Stat(filepath.Join(dir, "file.txt"))
+	if err != nil || read(filepath.Join(dir, "file.txt")) != "v2" || !info.ModTime().Equal(newer) {
+		t.Fatalf("Expected -N to fetch the newer file and keep its time, got %v", info.ModTime())
+	}
+
+	// --backups rotates the older copies
+	dir = t.TempDir()
+	app = newAppstate()
+	app.urlArgs.backups = 2
+	for _, version := range []string{"a", "b", "c", "d"} {
+		publish(version, newer)
+		download(app, dir)
+	}
+	for file, want := range map[string]string{"file.txt": "d", "file.txt.1": "c", "file.txt.2": "b"} {
+		if got := read(filepath.Join(dir, file)); got != want {
+			t.Errorf("Expected %s to hold %s, got %s", file, want, got)
+		}
+	}
+	if utils.FileExists(filepath.Join(dir, "file.txt.3")) {
+		t.Errorf("Expected only two backups to be kept")
+	}
+}
+
+func TestConcurrentEntriesWithTheSameName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond) // keep both downloads in flight
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "links.txt")
+	if err := os.WriteFile(listFile, []byte(server.URL+"/a/file.bin\n"+server.URL+"/b/file.bin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newAppstate()
+	app.urlArgs.jobs = 2
+	if err := app.downloadMultipleFiles(context.Background(), listFile, "", dir); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, name := range []string{"file.bin", "file.bin.1"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Expected both downloads to be saved, but got: %v", err)
+		}
+		got = append(got, string(data))
+	}
+	if got[0] == got[1] {
+		t.Fatalf("Expected each download in its own file, but both hold %s", got[0])
+	}
+}
+
+func TestMirrorPageRequisitesSpanHosts(t *testing.T) {
+	var mu sync.Mutex
+	fetched := make(map[string]bool)
+	record := func(r *http.Request) {
+		mu.Lock()
+		fetched[r.URL.Path] = true
+		mu.Unlock()
+	}
+	// Served as localhost, a different host from the 127.0.0.1 site below
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		record(r)
+		if r.URL.Path == "/pic.png" {
+			w.Header().Set("Content-Type", "image/png")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/cdn-page.html">more</a>`))
+	}))
+	defer cdn.Close()
+	cdnURL := strings.Replace(cdn.URL, "127.0.0.1", "localhost", 1)
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<a href="/a.html">a</a><a href="%s/external.html">external</a>`, cdnURL)
+		case "/a.html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<img src="%s/pic.png">`, cdnURL)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer site.Close()
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	app := newAppstate()
+	app.urlArgs.level = 1
+	app.urlArgs.pageRequisites = true
+	if err := app.downloadAndMirror(context.Background(), site.URL+"/", "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	// The image of a page at the last level is fetched from the other host,
+	// but links to that host are not followed
+	if !fetched["/pic.png"] || fetched["/external.html"] || fetched["/cdn-page.html"] {
+		t.Fatalf("Expected only the requisite from the other host, got %v", fetched)
+	}
+	if !utils.FileExists(filepath.Join("localhost", "pic.png")) {
+		t.Fatalf("Expected the requisite to be saved under its own host")
+	}
+
+	// --domains lets the crawl follow links onto the listed host
+	fetched = make(map[string]bool)
+	app = newAppstate()
+	app.urlArgs.domains = "localhost"
+	if err := app.downloadAndMirror(context.Background(), site.URL+"/", "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !fetched["/external.html"] || !fetched["/cdn-page.html"] {
+		t.Fatalf("Expected links onto the listed domain to be followed, got %v", fetched)
+	}
+}
+
+func TestMirrorAcceptRejectFilters(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/docs/manual.pdf?v=1">manual</a><a href="/private/secret.pdf">secret</a>
+<a href="/page.html">page</a><img src="/logo.png?v=3"><link href="/style.css"><script src="/app.js"></script>`))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/docs/guide.pdf">guide</a><a href="/docs/notes.pdf">notes</a>`))
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte("body {}"))
+		case "/robots.txt":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("data"))
+		}
+	}))
+	defer server.Close()
+
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	app := newAppstate()
+	app.urlArgs.acceptFlag = "pdf,*.png,css"
+	app.urlArgs.rejectRegex = regexp.MustCompile(`guide`)
+	app.urlArgs.rejectTypes = "text/css"
+	if err := app.downloadAndMirror(context.Background(), server.URL+"/", "", false, "/private"); err != nil {
+		t.Fatal(err)
+	}
+
+	host, _ := utils.ExtractDomain(server.URL)
+	for _, file := range []string{"docs/manual.pdf@v=1", "docs/notes.pdf", "logo.png@v=3"} {
+		if !utils.FileExists(filepath.Join(dir, host, file)) {
+			t.Errorf("Expected %s to be kept", file)
+		}
+	}
+	// Pages are crawled for links but removed, and style.css is refused by
+	// its Content-Type
+	for _, file := range []string{"index.html", "page.html", "style.css", "app.js"} {
+		if utils.FileExists(filepath.Join(dir, host, file)) {
+			t.Errorf("Expected %s to be rejected", file)
+		}
+	}
+	for _, path := range []string{"/private/secret.pdf", "/docs/guide.pdf", "/app.js"} {
+		if requested[path] {
+			t.Errorf("Expected %s not to be requested", path)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package appState
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -13,84 +17,118 @@ import (
 	"wget/utils"
 )
 
-func (app *AppState) mirrorAsyncDownload(outputFileName, urlStr, directory string) error {
+func (app *AppState) mirrorAsyncDownload(ctx context.Context, outputFileName, urlStr, directory string) error {
+	_, _, err := app.saveMirrorFile(ctx, outputFileName, urlStr, directory, nil)
+	return err
+}
+
+// errRejectedType is returned by saveMirrorFile when the Content-Type of the
+// response is rejected.
+var errRejectedType = errors.New("rejected Content-Type")
+
+// saveMirrorFile downloads urlStr below directory, mirroring the URL path, and
+// returns where the file was saved along with its Content-Type. A file that is
+// already on disk is not downloaded again. When acceptType is set and returns
+// false for the Content-Type, nothing is written and errRejectedType returned.
+func (app *AppState) saveMirrorFile(ctx context.Context, outputFileName, urlStr, directory string, acceptType func(string) bool) (string, string, error) {
 	app.processedURLs.Lock()
 	if processed, exists := app.processedURLs.urls[urlStr]; exists && processed {
 		app.processedURLs.Unlock()
-		return fmt.Errorf("URL already processed:\n%s", urlStr)
+		return "", "", fmt.Errorf("URL already processed:\n%s", urlStr)
 	}
 	app.processedURLs.Unlock()
 
 	// Parse the URL to get the path components
 	u, err := url.Parse(urlStr)
 	if err != nil {
-		return fmt.Errorf("error parsing URL:\n%v", err)
+		return "", "", fmt.Errorf("error parsing URL:\n%v", err)
 	}
 
 	// Create the necessary directories based on the URL path
 	rootPath, err := utils.ExpandPath(directory)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
-	pathComponents := strings.Split(strings.Trim(u.Path, "/"), "/")
-	relativeDirPath := filepath.Join(pathComponents[:len(pathComponents)-1]...)
-	fullDirPath := filepath.Join(rootPath, relativeDirPath)
-	fileName := pathComponents[len(pathComponents)-1]
+	relativeDirPath := path.Dir(u.Path)
+	if strings.HasSuffix(u.Path, "/") {
+		relativeDirPath = u.Path
+	}
+	// Cleaning the path as rooted keeps an escaped "%2F.." from climbing out
+	// of the mirror
+	relativeDirPath = strings.TrimPrefix(path.Clean("/"+relativeDirPath), "/")
+	fullDirPath := filepath.Join(rootPath, filepath.FromSlash(relativeDirPath))
+
+	// A copy from an earlier run is kept with --no-clobber and otherwise
+	// checked against the server, since --mirror implies -N
+	name := outputFileName
+	if name == "" {
+		name = utils.LocalFileName(u)
+	}
+	var plan outputPlan
+	existing := existingCopy(fullDirPath, name)
+	if existing != "" {
+		if plan = app.planOutput(existing, nameMirrored); plan.skip {
+			return existing, mime.TypeByExtension(filepath.Ext(existing)), nil
+		}
+	}
 
-	resp, err := utils.HttpRequest(urlStr)
+	resp, err := app.fetch(withIfModifiedSince(ctx, plan.since), urlStr, 0)
 	if err != nil {
-		return err
+		return "", "", err
+	}
+	body := app.resumableBody(ctx, resp, urlStr, 0)
+	defer body.Close()
+
+	if upToDate(plan, resp) {
+		contentType := resp.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(existing))
+		}
+		return existing, contentType, nil
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: status %s\nurl: %s", resp.Status, urlStr)
+		return "", "", &utils.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, URL: urlStr}
 	}
 
 	contentType := resp.Header.Get("Content-Type")
+	if acceptType != nil && !acceptType(contentType) {
+		return "", contentType, errRejectedType
+	}
 
-	if outputFileName == "" {
-		if fileName == "" || strings.HasSuffix(urlStr, "/") {
-			fileName = "index.html"
-		} else if contentType == "text/html" && !strings.HasSuffix(fileName, ".html") {
-			fileName += ".html"
-		}
-		outputFileName = filepath.Join(fullDirPath, fileName)
-	} else {
-		if contentType == "text/html" && !strings.HasSuffix(outputFileName, ".html") {
-			outputFileName += ".html"
-		}
-		outputFileName = filepath.Join(fullDirPath, outputFileName)
+	outputFileName = name
+	// Pages always end in .html so they open in a browser; with
+	// --adjust-extension every type with a known extension gets one
+	if isHTML(contentType) || app.urlArgs.adjustExtension {
+		outputFileName = utils.AdjustExtension(outputFileName, contentType)
 	}
+	outputFileName = filepath.Join(fullDirPath, outputFileName)
 
 	if fullDirPath != "" {
 		if _, err := os.Stat(fullDirPath); os.IsNotExist(err) {
 			err = os.MkdirAll(fullDirPath, 0o755)
 			if err != nil {
-				return fmt.Errorf("error creating path:\n%v", err)
+				return "", "", fmt.Errorf("error creating path:\n%w", err)
 			}
 		}
 	}
-	if utils.FileExists(outputFileName) {
-		return nil
-	}
 
-	var out *os.File
-	out, err = os.Create(outputFileName)
+	out, err := createPart(outputFileName)
 	if err != nil {
-		return fmt.Errorf("error creating file:\n%v", err)
+		return "", "", err
 	}
-	defer out.Close()
+	defer app.settle(out)
+	out.backups, out.modTime = app.urlArgs.backups, lastModified(resp)
 
-	var reader io.Reader = resp.Body
+	var reader io.Reader = body
 	var totalSize int64
 
 	// Get the content length for the progress (if available)
 	if length := resp.Header.Get("Content-Length"); length != "" {
 		totalSize, err = strconv.ParseInt(length, 10, 64)
 		if err != nil {
-			return fmt.Errorf("error parsing Content-Length:\n%v", err)
+			return "", "", fmt.Errorf("error parsing Content-Length:\n%v", err)
 		}
 	}
 
@@ -102,12 +140,12 @@ func (app *AppState) mirrorAsyncDownload(outputFileName, urlStr, directory strin
 	for {
 		n, err := reader.Read(buffer)
 		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading response body")
+			return "", "", fmt.Errorf("error reading response body:\n%w", err)
 		}
 
 		if n > 0 {
 			if _, err := out.Write(buffer[:n]); err != nil {
-				return fmt.Errorf("error writing to file:\n%v", err)
+				return "", "", fmt.Errorf("error writing to file:\n%w", err)
 			}
 			downloaded += int64(n)
 			app.showProgress(downloaded, totalSize, startTime) // Display progress
@@ -118,14 +156,32 @@ func (app *AppState) mirrorAsyncDownload(outputFileName, urlStr, directory strin
 		}
 	}
 
-	fmt.Printf("\n\033[32mDownloaded [%s]\033[0m\n", urlStr)
+	if err := out.commit(); err != nil {
+		return "", "", err
+	}
+	app.limits.addBytes(downloaded)
+	// A backup kept by --backup-converted belongs to the copy just replaced
+	os.Remove(outputFileName + ".orig")
+
+	fmt.Printf("\n\033[32mDownloaded [%s]\033[0m\n", utils.RedactURL(urlStr))
 
 	// Mark the URL as processed
 	app.processedURLs.Lock()
 	app.processedURLs.urls[urlStr] = true
 	app.processedURLs.Unlock()
 
-	return nil
+	return outputFileName, contentType, nil
+}
+
+// existingCopy returns where an earlier run saved name in dir, allowing for
+// an extension added for its Content-Type, or "" when it has no copy.
+func existingCopy(dir, name string) string {
+	for _, candidate := range utils.AdjustedNames(name) {
+		if file := filepath.Join(dir, candidate); utils.FileExists(file) {
+			return file
+		}
+	}
+	return ""
 }
 
 // Update the ShowProgress function with the correct speed format
@@ -5,36 +5,43 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"strconv"
 	"wget/utils"
 )
 
 func (app *AppState) downloadInBackground(file, urlStr, rateLimit string) error {
-	// Parse the URL to derive the output name
-	parsedURL, err := url.Parse(urlStr)
-	if err != nil {
+	// Check the URL before handing it to the background process
+	if _, err := url.Parse(urlStr); err != nil {
 		return fmt.Errorf("invalid URL")
 	}
-	outputName := filepath.Base(parsedURL.Path) // Get the file name from the URL
-	if file != "" {
-		outputName = file
-	}
 
 	path := "." // Default path to save the file
 	// Create the wget-log file to log output
 	logFile, err := os.OpenFile("wget-log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
-		return fmt.Errorf("error creating log file:\n%v", err)
+		return fmt.Errorf("error creating log file:\n%w", err)
 	}
 	defer logFile.Close()
 
 	// Ensure the output directory exists
 	if err := os.MkdirAll(path, os.ModePerm); err != nil {
-		return fmt.Errorf("error creating output directory:\n%v", err)
+		return fmt.Errorf("error creating output directory:\n%w", err)
 	}
-	cmd := exec.Command(os.Args[0], "-O="+outputName, "-P="+path, "--rate-limit="+rateLimit, urlStr)
+	// Without -O the background process derives the name itself, so it is
+	// numbered beside an existing file rather than overwriting it
+	args := []string{"-P=" + path, "--rate-limit=" + rateLimit}
+	if file != "" {
+		args = append(args, "-O="+file)
+	}
+	args = append(args, app.forwardedFlags()...)
+	cmd := exec.Command(os.Args[0], append(args, urlStr)...)
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
+	// Secrets travel through the environment so they never show up in the
+	// process list or in wget-log
+	cmd.Env = append(os.Environ(),
+		"WGET_PASSWORD="+app.urlArgs.credentials.Password,
+		"WGET_BEARER_TOKEN="+app.urlArgs.credentials.BearerToken)
 
 	fmt.Println("Output will be written to \"wget-log\".")
 
@@ -56,3 +63,63 @@ func (app *AppState) downloadInBackground(file, urlStr, rateLimit string) error
 
 	return nil
 }
+
+// forwardedFlags rebuilds the optional flags that the background process
+// needs to behave like the foreground one would have.
+func (app *AppState) forwardedFlags() []string {
+	var flags []string
+	if app.urlArgs.continueDownload {
+		flags = append(flags, "--continue")
+	}
+	if app.urlArgs.keepSession {
+		flags = append(flags, "--keep-session-cookies")
+	}
+	if app.urlArgs.checksum != nil {
+		flags = append(flags, "--checksum="+app.urlArgs.checksum.String())
+	}
+	if app.urlArgs.segments > 1 {
+		flags = append(flags, "--segments="+strconv.Itoa(app.urlArgs.segments))
+	}
+	if app.urlArgs.contentDisposition {
+		flags = append(flags, "--content-disposition")
+	}
+	if app.urlArgs.noClobber {
+		flags = append(flags, "--no-clobber")
+	}
+	if app.urlArgs.timestamping {
+		flags = append(flags, "--timestamping")
+	}
+	if app.urlArgs.backups > 0 {
+		flags = append(flags, "--backups="+strconv.Itoa(app.urlArgs.backups))
+	}
+
+	valued := []struct{ name, value string }{
+		{"--tries=", app.urlArgs.tries},
+		{"--waitretry=", app.urlArgs.waitRetry},
+		{"--retry-on-http-error=", app.urlArgs.retryOnHTTPError},
+		{"--connect-timeout=", app.urlArgs.connectTimeout},
+		{"--read-timeout=", app.urlArgs.readTimeout},
+		{"--timeout=", app.urlArgs.timeout},
+		{"--max-idle-per-host=", app.urlArgs.maxIdlePerHost},
+		{"--proxy=", app.urlArgs.proxy},
+		{"--user=", app.urlArgs.credentials.User},
+		{"--load-cookies=", app.urlArgs.loadCookies},
+		{"--save-cookies=", app.urlArgs.saveCookies},
+		{"--user-agent=", app.urlArgs.request.UserAgent},
+		{"--referer=", app.urlArgs.request.Referer},
+		{"--method=", app.urlArgs.request.Method},
+		{"--body-data=", app.urlArgs.bodyData},
+		{"--body-file=", app.urlArgs.bodyFile},
+	}
+	for _, flag := range valued {
+		if flag.value != "" {
+			flags = append(flags, flag.name+flag.value)
+		}
+	}
+	for name, values := range app.urlArgs.request.Headers {
+		for _, value := range values {
+			flags = append(flags, "--header="+name+": "+value)
+		}
+	}
+	return flags
+}
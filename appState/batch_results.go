@@ -0,0 +1,107 @@
+package appState
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"wget/utils"
+)
+
+// downloadResult is the outcome of one URL of an -i file.
+type downloadResult struct {
+	line     int // line of the -i file the URL came from
+	url      string
+	status   string // HTTP status line, empty when no response was received
+	bytes    int64  // bytes received in this run
+	duration time.Duration
+	err      error
+}
+
+// batchResults collects the outcome of every URL of an -i file as the
+// workers finish them.
+type batchResults struct {
+	sync.Mutex
+	results []downloadResult
+}
+
+func (b *batchResults) add(result downloadResult) {
+	b.Lock()
+	defer b.Unlock()
+	b.results = append(b.results, result)
+}
+
+// failed returns the results that ended in an error.
+func (b *batchResults) failed() []downloadResult {
+	b.Lock()
+	defer b.Unlock()
+	var failed []downloadResult
+	for _, result := range b.results {
+		if result.err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// print writes one row per URL to stdout, in the order of the -i file.
+func (b *batchResults) print() {
+	b.Lock()
+	defer b.Unlock()
+	sort.Slice(b.results, func(i, j int) bool { return b.results[i].line < b.results[j].line })
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nURL\tSTATUS\tBYTES\tDURATION\tERROR")
+	for _, r := range b.results {
+		status, errText := r.status, "-"
+		if status == "" {
+			status = "-"
+		}
+		if r.err != nil {
+			// Error messages span several lines; keep each row on one
+			errText = strings.Join(strings.Fields(r.err.Error()), " ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", utils.RedactURL(r.url), status, r.bytes, r.duration.Round(time.Millisecond), errText)
+	}
+	w.Flush()
+}
+
+// writeFailedList writes the URLs that failed to path, one per line, so they
+// can be retried by passing the file back to -i.
+func (b *batchResults) writeFailedList(path string) error {
+	var lines strings.Builder
+	for _, result := range b.failed() {
+		lines.WriteString(result.url + "\n")
+	}
+	if err := os.WriteFile(path, []byte(lines.String()), 0o644); err != nil {
+		return fmt.Errorf("error writing failed list:\n%w", err)
+	}
+	return nil
+}
+
+// err summarises the failures as a single error whose exit code follows
+// wget: when different kinds of errors occurred the lowest code wins, except
+// that the generic code 1 only applies when nothing more specific did.
+func (b *batchResults) err() error {
+	failed := b.failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	code := 0
+	for _, result := range failed {
+		c := utils.ExitCode(result.err)
+		switch {
+		case code == 0, code == utils.ExitGeneric:
+			code = c
+		case c != utils.ExitGeneric && c < code:
+			code = c
+		}
+	}
+	b.Lock()
+	total := len(b.results)
+	b.Unlock()
+	return &utils.ExitError{Code: code, Err: fmt.Errorf("error: %d of %d downloads failed", len(failed), total)}
+}
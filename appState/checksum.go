@@ -0,0 +1,69 @@
+package appState
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"wget/utils"
+)
+
+// newVerifier returns a hash for checksum, primed with the first offset bytes
+// already on disk at path when a download is being resumed. It returns nil
+// when no checksum was requested.
+func newVerifier(checksum *utils.Checksum, path string, offset int64) (hash.Hash, error) {
+	if checksum == nil {
+		return nil, nil
+	}
+	h := checksum.NewHash()
+	if offset == 0 {
+		return h, nil
+	}
+
+	existing, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading partial file:\n%w", err)
+	}
+	defer existing.Close()
+	if _, err := io.CopyN(h, existing, offset); err != nil {
+		return nil, fmt.Errorf("error reading partial file:\n%w", err)
+	}
+	return h, nil
+}
+
+// verifyDownload checks the digest of a finished download before it is
+// committed. On a mismatch the part file is moved aside to final.corrupt so
+// it is never mistaken for a good copy.
+func verifyDownload(checksum *utils.Checksum, h hash.Hash, out *partFile) error {
+	if checksum == nil {
+		return nil
+	}
+	if err := checksum.Verify(h); err != nil {
+		out.File.Close()
+		out.done = true
+		quarantine := out.final + ".corrupt"
+		if rerr := os.Rename(out.Name(), quarantine); rerr != nil {
+			os.Remove(out.Name())
+			return fmt.Errorf("error verifying %s:\n%w\nthe file was deleted", out.final, err)
+		}
+		return fmt.Errorf("error verifying %s:\n%w\nthe file was moved to %s", out.final, err, quarantine)
+	}
+	fmt.Printf("checksum verified (%s)\n", checksum.Algorithm)
+	return nil
+}
+
+// verifyFile hashes a part file already on disk, for downloads whose bytes do
+// not arrive in order.
+func verifyFile(checksum *utils.Checksum, out *partFile) error {
+	if checksum == nil {
+		return nil
+	}
+	h := checksum.NewHash()
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading file:\n%w", err)
+	}
+	if _, err := io.Copy(h, out); err != nil {
+		return fmt.Errorf("error reading file:\n%w", err)
+	}
+	return verifyDownload(checksum, h, out)
+}
@@ -0,0 +1,171 @@
+package appState
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// outputPlan is what a download does about the file it is about to write.
+type outputPlan struct {
+	path  string    // where to save, which may be a numbered name
+	skip  bool      // the file is kept as it is and nothing is requested
+	since time.Time // with -N, the local copy's time for If-Modified-Since
+	// reserved marks path as taken by this download until releaseOutput
+	reserved bool
+}
+
+// outputSource tells planOutput where the name of a download came from.
+type outputSource int
+
+const (
+	nameFromURL  outputSource = iota // derived from the URL or the server
+	nameGiven                        // given with -O or an out= option
+	nameMirrored                     // a file saved by --mirror
+)
+
+// planOutput decides what to do when final already exists. With --continue
+// the file is resumed; --no-clobber keeps it; -N asks the server whether it
+// changed; --backups replaces it after rotating the older copies; otherwise
+// a name derived from the URL is saved as final.1, final.2 and so on, while
+// a name given with -O or out= is overwritten, as wget does. Files saved by
+// a mirror are never numbered and are time-stamped by default, since
+// --mirror implies -N. A name another download of the run is writing counts
+// as existing, and the chosen path is reserved until releaseOutput.
+func (app *AppState) planOutput(final string, source outputSource) outputPlan {
+	if source == nameMirrored {
+		return app.planExisting(final, source)
+	}
+	app.outputs.Lock()
+	defer app.outputs.Unlock()
+	plan := app.planExisting(final, source)
+	if !plan.skip {
+		app.outputs.paths[plan.path] = true
+		plan.reserved = true
+	}
+	return plan
+}
+
+// releaseOutput frees the path planOutput reserved for plan once its
+// download is over.
+func (app *AppState) releaseOutput(plan outputPlan) {
+	if !plan.reserved {
+		return
+	}
+	app.outputs.Lock()
+	delete(app.outputs.paths, plan.path)
+	app.outputs.Unlock()
+}
+
+// planExisting is planOutput without the reservation, which mirrors do not
+// need as they fetch every URL once.
+func (app *AppState) planExisting(final string, source outputSource) outputPlan {
+	if app.urlArgs.continueDownload {
+		return outputPlan{path: final}
+	}
+	if source != nameMirrored && app.outputs.paths[final] {
+		if app.urlArgs.noClobber {
+			fmt.Printf("File '%s' already there; not retrieving.\n", final)
+			return outputPlan{path: final, skip: true}
+		}
+		return outputPlan{path: app.numberedName(final)}
+	}
+	info, err := os.Stat(final)
+	if err != nil || info.IsDir() {
+		return outputPlan{path: final}
+	}
+	switch {
+	case app.urlArgs.noClobber:
+		fmt.Printf("File '%s' already there; not retrieving.\n", final)
+		return outputPlan{path: final, skip: true}
+	case app.urlArgs.timestamping || source == nameMirrored:
+		return outputPlan{path: final, since: info.ModTime()}
+	case app.urlArgs.backups > 0, source == nameGiven:
+		return outputPlan{path: final}
+	}
+	return outputPlan{path: app.numberedName(final)}
+}
+
+// numberedName returns the first of final.1, final.2, ... that is free on
+// disk and not reserved by another download.
+func (app *AppState) numberedName(final string) string {
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s.%d", final, n)
+		if app.outputs.paths[name] {
+			continue
+		}
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(partPath(name)); os.IsNotExist(err) {
+				return name
+			}
+		}
+	}
+}
+
+// withIfModifiedSince returns a context whose requests ask the server to
+// answer 304 Not Modified unless the resource changed after since.
+func withIfModifiedSince(ctx context.Context, since time.Time) context.Context {
+	if since.IsZero() {
+		return ctx
+	}
+	headers, _ := ctx.Value(entryHeadersKey{}).(http.Header)
+	headers = headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("If-Modified-Since", since.UTC().Format(http.TimeFormat))
+	return context.WithValue(ctx, entryHeadersKey{}, headers)
+}
+
+// upToDate reports, for a download planned with -N, whether the local copy
+// can be kept: the server answered 304, or its Last-Modified is no newer
+// than the local file and the sizes match. Servers that send no
+// Last-Modified are always downloaded from again.
+func upToDate(plan outputPlan, resp *http.Response) bool {
+	if plan.since.IsZero() {
+		return false
+	}
+	fresh := resp.StatusCode == http.StatusNotModified
+	if !fresh && resp.StatusCode == http.StatusOK {
+		modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		info, statErr := os.Stat(plan.path)
+		fresh = err == nil && statErr == nil && !modified.After(plan.since) &&
+			(resp.ContentLength < 0 || resp.ContentLength == info.Size())
+	}
+	if fresh {
+		fmt.Printf("Server file no newer than local file '%s' -- not retrieving.\n", plan.path)
+	}
+	return fresh
+}
+
+// lastModified returns the server's Last-Modified time for resp, or the zero
+// time when it sent none.
+func lastModified(resp *http.Response) time.Time {
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return modified
+}
+
+// rotateBackups makes room for a new final with --backups=n: final.n-1 moves
+// to final.n and so on down to final, which becomes final.1.
+func rotateBackups(final string, n int) error {
+	if _, err := os.Stat(final); err != nil {
+		return nil
+	}
+	for i := n - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", final, i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", final, i+1)); err != nil {
+				return fmt.Errorf("error rotating backups:\n%w", err)
+			}
+		}
+	}
+	if err := os.Rename(final, final+".1"); err != nil {
+		return fmt.Errorf("error rotating backups:\n%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package appState
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"wget/utils"
+)
+
+// parseConvertArgs parses the arguments of the convert-links subcommand:
+//
+//	convert-links [-K|--backup-converted] [--strip-params=LIST] DIR
+func (app *AppState) parseConvertArgs(args []string) error {
+	for _, arg := range args {
+		if arg == "-K" || arg == "--backup-converted" {
+			app.urlArgs.backupConverted = true
+		} else if strings.HasPrefix(arg, "--strip-params=") {
+			app.urlArgs.stripParams = arg[len("--strip-params="):]
+		} else if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("error: unknown convert-links option '%s'", arg)
+		} else if app.urlArgs.convertDir != "" {
+			return fmt.Errorf("error: convert-links takes a single directory")
+		} else {
+			app.urlArgs.convertDir = arg
+		}
+	}
+	if app.urlArgs.convertDir == "" {
+		return fmt.Errorf("error: convert-links needs the directory a mirror was saved in")
+	}
+	return nil
+}
+
+// convertMirrorDir converts the links of every page and stylesheet in a
+// mirror that is already on disk. dir is where the mirror was run, so each
+// of its subdirectories holds the files of one host; the URL of each file
+// is read back from its path and nothing is downloaded.
+func (app *AppState) convertMirrorDir(dir string) error {
+	root, err := utils.ExpandPath(dir)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return fmt.Errorf("error reading mirror directory:\n%w", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("error: %s is not a directory", dir)
+	}
+
+	converter := utils.LinkConverter{
+		Backup:      app.urlArgs.backupConverted,
+		StripParams: utils.SplitList(app.urlArgs.stripParams),
+	}
+	var saved []savedFile
+	err = filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(file, ".orig") || strings.HasSuffix(file, partSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		host, filePath, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		if !ok {
+			// Files beside the host directories are not part of the mirror
+			return nil
+		}
+		fileURL := (&url.URL{Scheme: "http", Host: host, Path: "/" + filePath}).String()
+		for _, savedURL := range savedURLs(file, host, filePath) {
+			converter.Add(savedURL, file)
+		}
+		ext := strings.ToLower(filepath.Ext(file))
+		saved = append(saved, savedFile{
+			url:  fileURL,
+			path: file,
+			html: ext == ".html" || ext == ".htm",
+			css:  ext == ".css",
+		})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading mirror directory:\n%w", err)
+	}
+	convertLinks(&converter, saved)
+	return nil
+}
+
+// savedURLs returns the URLs the mirror saves at filePath on host, undoing
+// what utils.LocalFileName and utils.AdjustExtension do to the URL: the
+// index.html of a directory, a query kept after utils.QuerySeparator and an
+// extension added for the Content-Type.
+func savedURLs(file, host, filePath string) []string {
+	dir, name := path.Split("/" + filePath)
+	names := []string{name}
+	if ext := utils.AddedExtension(name); ext != "" && !utils.FileExists(strings.TrimSuffix(file, ext)) {
+		names = append(names, strings.TrimSuffix(name, ext))
+	}
+
+	var urls []string
+	add := func(name, query string) {
+		if name == "index.html" {
+			urls = append(urls, (&url.URL{Scheme: "http", Host: host, Path: dir, RawQuery: query}).String())
+		}
+		urls = append(urls, (&url.URL{Scheme: "http", Host: host, Path: dir + name, RawQuery: query}).String())
+	}
+	for _, name := range names {
+		add(name, "")
+		if base, query, ok := strings.Cut(name, utils.QuerySeparator); ok {
+			add(base, query)
+		}
+	}
+	return urls
+}
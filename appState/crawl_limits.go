@@ -0,0 +1,105 @@
+package appState
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// CrawlLimits enforces --level, --quota, --max-pages and --no-parent for a
+// mirror run. Every link the mirror finds passes through admit, and every
+// page and file it starts passes through takePage or quotaLeft, so the
+// limits hold however the crawl is scheduled.
+type CrawlLimits struct {
+	sync.Mutex
+	level    int   // deepest link depth followed, 0 for no limit
+	quota    int64 // total bytes to download, 0 for no limit
+	maxPages int   // HTML pages to fetch, 0 for no limit
+	noParent bool
+
+	host      string // host and directory of the start URL for --no-parent
+	parentDir string
+	pages     int
+	bytes     int64
+	announced bool // whether the quota message was printed
+}
+
+// start takes the limits from args, resets the counters and records the
+// start URL the --no-parent check is relative to.
+func (l *CrawlLimits) start(startURL string, args UrlArgs) {
+	l.Lock()
+	defer l.Unlock()
+	l.level, l.quota, l.maxPages, l.noParent = args.level, args.quota, args.maxPages, args.noParent
+	l.pages, l.bytes, l.announced = 0, 0, false
+	u, err := url.Parse(startURL)
+	if err != nil {
+		return
+	}
+	l.host = strings.ToLower(u.Host)
+	l.parentDir = u.Path
+	if l.parentDir == "" {
+		l.parentDir = "/"
+	} else if !strings.HasSuffix(l.parentDir, "/") {
+		l.parentDir = strings.TrimSuffix(path.Dir(l.parentDir), "/") + "/"
+	}
+}
+
+// admit reports whether a link found at the given depth may be followed,
+// and if not, why.
+func (l *CrawlLimits) admit(rawURL string, depth int) (bool, string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.level > 0 && depth > l.level {
+		return false, fmt.Sprintf("deeper than --level=%d", l.level)
+	}
+	if l.noParent {
+		u, err := url.Parse(rawURL)
+		if err == nil && strings.EqualFold(u.Host, l.host) && !strings.HasPrefix(u.Path+"/", l.parentDir) {
+			return false, "above the start directory (--no-parent)"
+		}
+	}
+	return true, ""
+}
+
+// takePage counts a page about to be fetched, reporting false once
+// --max-pages pages were fetched or the quota is used up.
+func (l *CrawlLimits) takePage() bool {
+	l.Lock()
+	defer l.Unlock()
+	if !l.quotaLeftLocked() {
+		return false
+	}
+	if l.maxPages > 0 && l.pages >= l.maxPages {
+		return false
+	}
+	l.pages++
+	return true
+}
+
+// quotaLeft reports whether another download may start. Like wget, a file
+// already in progress is finished even if it takes the total past the quota.
+func (l *CrawlLimits) quotaLeft() bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.quotaLeftLocked()
+}
+
+func (l *CrawlLimits) quotaLeftLocked() bool {
+	if l.quota <= 0 || l.bytes < l.quota {
+		return true
+	}
+	if !l.announced {
+		l.announced = true
+		fmt.Printf("Download quota of %d bytes exceeded, not starting new downloads\n", l.quota)
+	}
+	return false
+}
+
+// addBytes counts n downloaded bytes against the quota.
+func (l *CrawlLimits) addBytes(n int64) {
+	l.Lock()
+	l.bytes += n
+	l.Unlock()
+}
@@ -0,0 +1,195 @@
+package appState
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"wget/utils"
+)
+
+// entryHeadersKey is the context key for the headers of a single -i entry.
+type entryHeadersKey struct{}
+
+// withEntryHeaders returns a context whose requests also carry headers, which
+// replace any --header of the same name.
+func withEntryHeaders(ctx context.Context, headers http.Header) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, entryHeadersKey{}, headers)
+}
+
+// contextOptions is requestOptions plus any headers attached to ctx by
+// withEntryHeaders.
+func (app *AppState) contextOptions(ctx context.Context, rawURL string) utils.RequestOptions {
+	opts := app.requestOptions(rawURL)
+	headers, ok := ctx.Value(entryHeadersKey{}).(http.Header)
+	if !ok {
+		return opts
+	}
+	opts.Headers = opts.Headers.Clone()
+	if opts.Headers == nil {
+		opts.Headers = make(http.Header)
+	}
+	for name, values := range headers {
+		opts.Headers[name] = values
+	}
+	return opts
+}
+
+// fetch requests url from offset, retrying transient failures according to
+// the retry policy built from --tries, --waitretry and --retry-on-http-error.
+func (app *AppState) fetch(ctx context.Context, url string, offset int64) (*http.Response, error) {
+	return app.retry.Do(ctx, func() (*http.Response, error) {
+		return utils.HttpRangeRequest(ctx, app.client, app.contextOptions(ctx, url), url, offset)
+	})
+}
+
+// authorizeHost records the host of a URL the user asked for directly, making
+// it eligible for credentials. Hosts discovered while mirroring never are.
+func (app *AppState) authorizeHost(rawURL string) {
+	host, err := utils.ExtractDomain(rawURL)
+	if err != nil || host == "" {
+		return
+	}
+	app.auth.Lock()
+	app.auth.hosts[strings.ToLower(host)] = true
+	app.auth.Unlock()
+}
+
+// requestOptions returns the options for a request to rawURL. Credentials from
+// the command line, or from ~/.netrc when none were given, are attached only
+// when rawURL points at a host recorded by authorizeHost.
+func (app *AppState) requestOptions(rawURL string) utils.RequestOptions {
+	opts := app.urlArgs.request
+	host, err := utils.ExtractDomain(rawURL)
+	if err != nil {
+		return opts
+	}
+	host = strings.ToLower(host)
+
+	app.auth.Lock()
+	defer app.auth.Unlock()
+	if !app.auth.hosts[host] {
+		return opts
+	}
+	if !app.urlArgs.credentials.Empty() {
+		opts.Credentials = app.urlArgs.credentials
+		return opts
+	}
+	creds, cached := app.auth.netrc[host]
+	if !cached {
+		creds, _, err = utils.LookupNetrc(utils.NetrcPath(), host)
+		if err != nil {
+			fmt.Println(err)
+		}
+		app.auth.netrc[host] = creds
+	}
+	opts.Credentials = creds
+	return opts
+}
+
+// resumingBody reads a response body and, when the connection drops in the
+// middle of the transfer, requests the rest from the last byte received so
+// the caller sees one uninterrupted stream.
+type resumingBody struct {
+	ctx     context.Context
+	app     *AppState
+	url     string
+	body    io.ReadCloser
+	offset  int64 // position of the next byte in the remote file
+	attempt int
+}
+
+// outputName returns the name to save url under when -O was not given.
+// With --content-disposition and --continue the server is asked with a HEAD
+// request first, so the name is known before looking for a partial file;
+// otherwise, or when the HEAD reply suggests no name, the downloaders take it
+// from the reply to the download itself with responseName. Until then the
+// name comes from the URL.
+func (app *AppState) outputName(ctx context.Context, url string) string {
+	if app.urlArgs.contentDisposition && app.urlArgs.continueDownload {
+		head, err := app.retry.Do(ctx, func() (*http.Response, error) {
+			return utils.HttpHeadRequest(ctx, app.client, app.contextOptions(ctx, url), url)
+		})
+		if err == nil {
+			head.Body.Close()
+			if name := utils.ContentDispositionFileName(head.Header.Get("Content-Disposition")); name != "" {
+				return name
+			}
+		}
+	}
+	return utils.URLFileName(url)
+}
+
+// responseName returns the name the Content-Disposition header of resp
+// suggests with --content-disposition, or "" when it suggests none.
+func (app *AppState) responseName(resp *http.Response) string {
+	if !app.urlArgs.contentDisposition {
+		return ""
+	}
+	return utils.ContentDispositionFileName(resp.Header.Get("Content-Disposition"))
+}
+
+// resumableBody wraps resp.Body, which was requested from offset in the
+// remote file. Only a 206 reply starts there: a 200 reply means the server
+// ignored the range and sent the file from byte zero, as openOutput writes
+// it. Servers that do not accept byte ranges get the body back unchanged.
+func (app *AppState) resumableBody(ctx context.Context, resp *http.Response, url string, offset int64) io.ReadCloser {
+	if resp.StatusCode != http.StatusPartialContent {
+		if resp.Header.Get("Accept-Ranges") != "bytes" {
+			return resp.Body
+		}
+		offset = 0
+	}
+	return &resumingBody{ctx: ctx, app: app, url: url, body: resp.Body, offset: offset, attempt: 1}
+}
+
+func (r *resumingBody) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == nil || err == io.EOF || !utils.IsRetryableError(err) || r.attempt >= r.app.retry.Tries || r.ctx.Err() != nil {
+		return n, err
+	}
+
+	r.body.Close()
+	wait := r.app.retry.Backoff(r.attempt)
+	r.attempt++
+	fmt.Printf("\nconnection lost at byte %d: %v\nresuming in %s (attempt %d of %d)\n",
+		r.offset, err, wait.Round(time.Millisecond), r.attempt, r.app.retry.Tries)
+	if utils.Sleep(r.ctx, wait) != nil {
+		r.body = io.NopCloser(&errReader{err})
+		return n, err
+	}
+
+	resp, rerr := utils.HttpRangeRequest(r.ctx, r.app.client, r.app.contextOptions(r.ctx, r.url), r.url, r.offset)
+	if rerr != nil {
+		r.body = io.NopCloser(&errReader{err})
+		return n, err
+	}
+	start, _, _, rerr := utils.ParseContentRange(resp.Header.Get("Content-Range"))
+	if resp.StatusCode != http.StatusPartialContent || rerr != nil || start != r.offset {
+		resp.Body.Close()
+		r.body = io.NopCloser(&errReader{err})
+		return n, err
+	}
+	r.body = resp.Body
+	return n, nil
+}
+
+func (r *resumingBody) Close() error {
+	return r.body.Close()
+}
+
+// errReader keeps returning the error that ended a stream that could not be
+// resumed.
+type errReader struct {
+	err error
+}
+
+func (e *errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
@@ -0,0 +1,144 @@
+package appState
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"wget/utils"
+)
+
+// downloadJob is one entry of an -i file waiting for a worker.
+type downloadJob struct {
+	line      int // line of the -i file the entry starts on
+	url       string
+	output    string // out=, relative to the entry's directory
+	directory string // dir=, relative to -P unless absolute
+	checksum  *utils.Checksum
+	headers   http.Header
+	rateLimit string
+}
+
+// inputList reads the entries of an -i file. Each entry is a URL at the start
+// of a line, optionally followed by a checksum, and then any number of
+// indented "key=value" option lines that apply to that URL only:
+//
+//	# nightly build
+//	https://example.com/app.tar.gz
+//	  out=app-nightly.tar.gz
+//	  dir=builds
+//	  checksum=sha256:9f86d0...
+//	  header=Authorization: Bearer abc
+//	  rate-limit=500k
+//
+// Blank lines and lines starting with # are ignored.
+type inputList struct {
+	scanner *bufio.Scanner
+	lineNo  int
+	current *downloadJob
+	invalid error // a problem with current, reported when it is returned
+}
+
+func newInputList(r io.Reader) *inputList {
+	return &inputList{scanner: bufio.NewScanner(r)}
+}
+
+// next returns the next entry. An entry with invalid options is returned
+// together with the error so the caller can report it; io.EOF marks the end
+// of the list, after which err tells whether it was read completely.
+func (l *inputList) next() (downloadJob, error) {
+	for l.scanner.Scan() {
+		l.lineNo++
+		line := l.scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Indented lines hold options for the entry above them
+		if strings.TrimLeft(line, " \t") != line {
+			if l.current == nil {
+				return downloadJob{line: l.lineNo}, fmt.Errorf("line %d: option '%s' has no URL above it", l.lineNo, trimmed)
+			}
+			if l.invalid == nil {
+				if err := l.current.setOption(trimmed); err != nil {
+					l.invalid = fmt.Errorf("line %d: %v", l.lineNo, err)
+				}
+			}
+			continue
+		}
+
+		job, err := l.current, l.invalid
+		l.current, l.invalid = l.startEntry(trimmed)
+		if job != nil {
+			return *job, err
+		}
+	}
+	if l.current != nil {
+		job, err := *l.current, l.invalid
+		l.current = nil
+		return job, err
+	}
+	return downloadJob{}, io.EOF
+}
+
+// err returns the error that stopped reading the list early, if any.
+func (l *inputList) err() error {
+	if err := l.scanner.Err(); err != nil {
+		return fmt.Errorf("error reading input file:\n%w", err)
+	}
+	return nil
+}
+
+// startEntry parses a URL line, which may carry a checksum after the URL.
+func (l *inputList) startEntry(line string) (*downloadJob, error) {
+	fields := strings.Fields(line)
+	job := &downloadJob{line: l.lineNo, url: fields[0]}
+	if len(fields) > 2 {
+		return job, fmt.Errorf("line %d: unexpected '%s' after the checksum", l.lineNo, fields[2])
+	}
+	if len(fields) == 2 {
+		if err := job.setOption("checksum=" + fields[1]); err != nil {
+			return job, fmt.Errorf("line %d: %v", l.lineNo, err)
+		}
+	}
+	return job, nil
+}
+
+// setOption applies one "key=value" option line to the entry.
+func (job *downloadJob) setOption(option string) error {
+	key, value, found := strings.Cut(option, "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !found || value == "" {
+		return fmt.Errorf("invalid option '%s', expected key=value", option)
+	}
+
+	switch key {
+	case "out":
+		job.output = value
+	case "dir":
+		job.directory = value
+	case "checksum":
+		checksum, err := utils.ParseChecksum(value)
+		if err != nil {
+			return err
+		}
+		job.checksum = checksum
+	case "header":
+		opts := utils.RequestOptions{Headers: job.headers}
+		if err := opts.AddHeader(value); err != nil {
+			return err
+		}
+		job.headers = opts.Headers
+	case "rate-limit":
+		if !validRateLimit(value) {
+			return fmt.Errorf("invalid rate-limit '%s'", value)
+		}
+		job.rateLimit = value
+	default:
+		return fmt.Errorf("unknown option '%s'", key)
+	}
+	return nil
+}
@@ -1,167 +1,384 @@
 package appState
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
-	"regexp"
+	"mime"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 	"wget/utils"
 
 	"golang.org/x/net/html"
 )
 
-// DownloadAndMirror downloads a page and its assets, recursively visiting links
-func (app *AppState) downloadAndMirror(url, rejectTypes string, convertLink bool, pathRejects string) error {
-	domain, err := utils.ExtractDomain(url)
-	if err != nil {
-		return fmt.Errorf("could not extract domain name for:\n%serror: %v", url, err)
+// crawlItem is a URL waiting in the mirror's frontier.
+type crawlItem struct {
+	url   string
+	depth int  // links followed from the start URL
+	page  bool // a document such as an <a href> target, so its links are followed in turn
+}
+
+// savedFile is a file the mirror kept, with the URL it came from.
+type savedFile struct {
+	url, path string
+	html, css bool // documents whose links --convert-links rewrites
+}
+
+// seenSet records every URL the mirror has queued so none is queued twice.
+type seenSet struct {
+	sync.Mutex
+	urls map[string]bool
+}
+
+// add marks url as seen, reporting false when it already was.
+func (s *seenSet) add(url string) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.urls[url] {
+		return false
 	}
+	s.urls[url] = true
+	return true
+}
+
+// mirrorOptions are the filters given on the command line for a mirror run.
+type mirrorOptions struct {
+	domain string
+	filter utils.URLFilter
 
-	app.muPages.Lock()
-	if app.visitedPages[url] {
-		app.muPages.Unlock()
-		return nil
+	spanHosts      bool
+	domains        []string // hosts outside the start host that may be followed
+	excludeDomains []string // hosts that are never followed
+	requisites     bool     // fetch what pages need to render, wherever it is
+	stripParams    []string // query parameters dropped from every URL
+}
+
+// resolve resolves a link found on the document at base into the canonical
+// URL the frontier and its seen set work with.
+func (opts mirrorOptions) resolve(base, link string) string {
+	return utils.CanonicalURL(utils.ResolveURL(base, link), opts.stripParams)
+}
+
+// splitDomains parses a comma separated --domains list.
+func splitDomains(list string) []string {
+	var domains []string
+	for _, domain := range strings.Split(list, ",") {
+		domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
 	}
-	app.visitedPages[url] = true
-	app.muPages.Unlock()
+	return domains
+}
 
-	// Check if we're at the root domain and force download of index.html
-	if (strings.TrimRight(url, "/") == "http://"+domain || strings.TrimRight(url, "/") == "https://"+domain) && app.count == 0 {
-		app.count++
-		indexURL := strings.TrimRight(url, "/")
-		app.downloadAsset(indexURL, domain, rejectTypes)
+// domainListed reports whether host is one of domains or a subdomain of one.
+func domainListed(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
 	}
+	return false
+}
 
-	// Fetch and get the HTML of the page
-	doc, err := fetchAndParsePage(url)
+// hostAllowed reports whether links to host may be followed. The start host
+// always may; other hosts need --span-hosts or a matching --domains entry,
+// and --exclude-domains overrides both.
+func (opts mirrorOptions) hostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	if domainListed(host, opts.excludeDomains) {
+		return false
+	}
+	if host == opts.domain {
+		return true
+	}
+	if len(opts.domains) > 0 {
+		return domainListed(host, opts.domains)
+	}
+	return opts.spanHosts
+}
+
+// DownloadAndMirror downloads a page and its assets, then the pages it links
+// to, breadth first. Each depth is fetched by a fixed pool of --jobs workers
+// and the links they find are queued in document order once the whole depth
+// is done, so the crawl order does not depend on which worker finished first.
+// --level, --quota, --max-pages and --no-parent bound the crawl.
+func (app *AppState) downloadAndMirror(ctx context.Context, url, rejectTypes string, convertLink bool, pathRejects string) error {
+	stripParams := utils.SplitList(app.urlArgs.stripParams)
+	url = utils.CanonicalURL(url, stripParams)
+	domain, err := utils.ExtractDomain(url)
 	if err != nil {
-		return fmt.Errorf("error fetching or parsing page:\n%v", err)
+		return fmt.Errorf("could not extract domain name for:\n%serror: %v", url, err)
 	}
+	opts := mirrorOptions{
+		domain: strings.ToLower(domain),
+		filter: utils.URLFilter{
+			Accept:      utils.SplitList(app.urlArgs.acceptFlag),
+			Reject:      utils.SplitList(rejectTypes),
+			AcceptRegex: app.urlArgs.acceptRegex,
+			RejectRegex: app.urlArgs.rejectRegex,
+			IncludeDirs: utils.SplitList(app.urlArgs.includeFlag),
+			ExcludeDirs: utils.SplitList(pathRejects),
+			AcceptTypes: utils.SplitList(app.urlArgs.acceptTypes),
+			RejectTypes: utils.SplitList(app.urlArgs.rejectTypes),
+		},
+		spanHosts:      app.urlArgs.spanHosts,
+		domains:        splitDomains(app.urlArgs.domains),
+		excludeDomains: splitDomains(app.urlArgs.excludeDomains),
+		requisites:     app.urlArgs.pageRequisites,
+		stripParams:    stripParams,
+	}
+	app.limits.start(url, app.urlArgs)
 
-	// Function to handle links and assets found on the page
-	handleLink := func(link, tagName string) {
-		app.semaphore <- struct{}{}
-		defer func() { <-app.semaphore }()
+	workers := app.urlArgs.jobs
+	if workers < 1 {
+		workers = defaultJobs
+	}
+	seen := seenSet{urls: map[string]bool{url: true}}
+	level := []crawlItem{{url: url, page: true}}
+	var saved []savedFile
 
-		baseURL := utils.ResolveURL(url, link)
-		if utils.IsRejectedPath(baseURL, pathRejects) {
-			fmt.Printf("Skipping Rejected file path: %s\n", baseURL)
-			return
+	for len(level) > 0 && ctx.Err() == nil {
+		found := make([][]crawlItem, len(level))
+		files := make([]savedFile, len(level))
+		errs := make([]error, len(level))
+		indexes := make(chan int)
+		var wg sync.WaitGroup
+		for i := 0; i < min(workers, len(level)); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range indexes {
+					found[i], files[i], errs[i] = app.crawl(ctx, level[i], opts)
+				}
+			}()
 		}
-		baseURLDomain, err := utils.ExtractDomain(baseURL)
-		if err != nil {
-			fmt.Println("Could not extract domain name for:", baseURLDomain, "\nError:", err)
-			return
+		for i := range level {
+			indexes <- i
 		}
+		close(indexes)
+		wg.Wait()
 
-		if baseURLDomain == domain {
-			if tagName == "a" {
-				if strings.HasSuffix(baseURL, "/") || strings.HasSuffix(baseURL, "/index.html") {
-					// Ensure index.html is downloaded first
-					indexURL := strings.TrimRight(baseURL, "/") + "/index.html"
-					if !app.visitedPages[indexURL] {
-						app.downloadAsset(indexURL, domain, rejectTypes)
-						app.downloadAndMirror(indexURL, rejectTypes, convertLink, pathRejects)
-					}
-				} else {
-					app.downloadAndMirror(baseURL, rejectTypes, convertLink, pathRejects)
-				}
-			}
-			app.downloadAsset(baseURL, domain, rejectTypes)
+		// Only the start page failing fails the mirror; other errors are
+		// reported as they happen
+		if level[0].depth == 0 && errs[0] != nil {
+			return fmt.Errorf("error fetching or parsing page:\n%w", errs[0])
 		}
-	}
 
-	var wg sync.WaitGroup
-	var processNode func(n *html.Node)
-
-	processNode = func(n *html.Node) {
-		if n.Type == html.ElementNode {
-			for _, attr := range n.Attr {
-				if utils.IsValidAttribute(n.Data, attr.Key) {
-					link := attr.Val
-					if link != "" {
-						wg.Add(1)
-						go func(link, tagName string) {
-							defer wg.Done()
-							handleLink(link, tagName)
-						}(link, n.Data)
-					}
-				}
-				// Check for inline styles
-				if attr.Key == "style" {
-					app.extractAndHandleStyleURLs(attr.Val, url, domain, rejectTypes)
-				}
+		var next []crawlItem
+		for i, links := range found {
+			if files[i].path != "" {
+				saved = append(saved, files[i])
 			}
-			// Check for <style> tags
-			if n.Data == "style" && n.FirstChild != nil {
-				app.extractAndHandleStyleURLs(n.FirstChild.Data, url, domain, rejectTypes)
+			for _, item := range links {
+				if seen.add(item.url) {
+					next = append(next, item)
+				}
 			}
 		}
-
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			processNode(c)
-		}
+		level = next
 	}
 
-	// Start processing the document
-	processNode(doc)
-
-	// Wait for all goroutines to complete
-	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-	// Convert links if the flag is set
 	if convertLink {
-		utils.ConvertLinks(url)
+		converter := utils.LinkConverter{Backup: app.urlArgs.backupConverted, StripParams: opts.stripParams}
+		convertLinks(&converter, saved)
 	}
-
 	return nil
 }
 
-func (app *AppState) extractAndHandleStyleURLs(styleContent, baseURL, domain, rejectTypes string) {
-	re := regexp.MustCompile(`url\(['"]?([^'"()]+)['"]?\)`)
-	matches := re.FindAllStringSubmatch(styleContent, -1)
-	for _, match := range matches {
-		if len(match) > 1 {
-			assetURL := utils.ResolveURL(baseURL, match[1])
-			app.downloadAsset(assetURL, domain, rejectTypes)
+// convertLinks rewrites the links in every saved page and stylesheet once
+// the crawl is done, when it is known which files exist locally.
+func convertLinks(converter *utils.LinkConverter, saved []savedFile) {
+	for _, file := range saved {
+		converter.Add(file.url, file.path)
+	}
+	start := time.Now()
+	converted := 0
+	for _, file := range saved {
+		var err error
+		switch {
+		case file.html:
+			err = converter.ConvertHTML(file.path, file.url)
+		case file.css:
+			err = converter.ConvertCSS(file.path, file.url)
+		default:
+			continue
+		}
+		if err != nil {
+			fmt.Printf("error converting links in %s:\n%v\n", file.path, err)
+			continue
 		}
+		converted++
 	}
+	fmt.Printf("Converted links in %d files in %s.\n", converted, time.Since(start).Round(time.Millisecond))
 }
 
-// fetchAndParsePage fetches the content of the URL and parses it as HTML
-func fetchAndParsePage(url string) (*html.Node, error) {
-	resp, err := utils.HttpRequest(url)
+// crawl downloads one item of the frontier and, for pages and stylesheets,
+// returns the links in it that may be followed.
+func (app *AppState) crawl(ctx context.Context, item crawlItem, opts mirrorOptions) ([]crawlItem, savedFile, error) {
+	if ctx.Err() != nil {
+		return nil, savedFile{}, ctx.Err()
+	}
+	if item.page && !app.limits.takePage() || !item.page && !app.limits.quotaLeft() {
+		return nil, savedFile{}, nil
+	}
+	if err := app.politeWait(ctx, item.url); err != nil {
+		return nil, savedFile{}, err
+	}
+
+	// Each host is mirrored into a directory of its own
+	host, err := utils.ExtractDomain(item.url)
 	if err != nil {
-		return nil, err
+		return nil, savedFile{}, err
+	}
+	// HTML pages are always fetched so their links can be followed; the
+	// filters apply to everything else once the Content-Type is known and
+	// before the body is read
+	acceptType := func(contentType string) bool {
+		if item.page && isHTML(contentType) {
+			return true
+		}
+		return opts.filter.AllowType(contentType) && opts.filter.AllowName(item.url)
 	}
-	defer resp.Body.Close()
+	fmt.Printf("Downloading: %s\n", utils.RedactURL(item.url))
+	path, contentType, err := app.saveMirrorFile(ctx, "", item.url, host, acceptType)
+	if errors.Is(err, errRejectedType) {
+		fmt.Printf("Skipping rejected file: %s (%s)\n", utils.RedactURL(item.url), contentType)
+		return nil, savedFile{}, nil
+	}
+	if err != nil {
+		fmt.Println(err)
+		return nil, savedFile{}, err
+	}
+	file := savedFile{url: item.url, path: path, html: isHTML(contentType), css: isCSS(contentType, path)}
+	if file.css {
+		links, err := app.parseStylesheet(ctx, path, item, opts)
+		return links, file, err
+	}
+	if !item.page || !file.html {
+		return nil, file, nil
+	}
+
+	links, err := app.parsePage(ctx, path, item, opts)
+	// A page kept only to find links is removed once they are read
+	if !opts.filter.AllowName(item.url) || !opts.filter.AllowType(contentType) {
+		fmt.Printf("Removing %s since it should be rejected\n", path)
+		os.Remove(path)
+		file = savedFile{}
+	}
+	return links, file, err
+}
+
+// isHTML reports whether a Content-Type header names an HTML document.
+func isHTML(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/html"
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: status %s", resp.Status)
+// isCSS reports whether a saved file is a stylesheet, going by its
+// Content-Type or, when the server sent none, its extension.
+func isCSS(contentType, path string) bool {
+	if contentType == "" {
+		return strings.EqualFold(filepath.Ext(path), ".css")
 	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/css"
+}
 
-	return html.Parse(resp.Body)
+// parseStylesheet reads the saved stylesheet at path and returns the fonts,
+// images and imported stylesheets it references that may be followed. They
+// belong to whatever page pulled the stylesheet in, so they keep its depth.
+func (app *AppState) parseStylesheet(ctx context.Context, path string, sheet crawlItem, opts mirrorOptions) ([]crawlItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	var links []crawlItem
+	for _, link := range utils.ExtractCSSLinks(string(data)) {
+		item := crawlItem{url: opts.resolve(sheet.url, link), depth: sheet.depth}
+		if app.follow(ctx, item, opts) {
+			links = append(links, item)
+		}
+	}
+	return links, nil
 }
 
-func (app *AppState) downloadAsset(fileURL, domain, rejectTypes string) {
-	app.muAssets.Lock()
-	if app.visitedAssets[fileURL] {
-		app.muAssets.Unlock()
-		return
+// parsePage reads the saved page at path and returns the links on it that
+// may be followed.
+func (app *AppState) parsePage(ctx context.Context, path string, page crawlItem, opts mirrorOptions) ([]crawlItem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer file.Close()
+	doc, err := html.Parse(file)
+	if err != nil {
+		fmt.Printf("error parsing %s:\n%v\n", path, err)
+		return nil, err
 	}
-	app.visitedAssets[fileURL] = true
-	app.muAssets.Unlock()
+	return app.pageLinks(ctx, doc, page, opts), nil
+}
 
-	if fileURL == "" || !strings.HasPrefix(fileURL, "http") {
-		fmt.Printf("Invalid URL: %s\n", fileURL)
-		return
+// pageLinks returns the links and assets on a page, in document order, that
+// pass the mirror's filters. They are resolved against the page's <base href>
+// when it has one.
+func (app *AppState) pageLinks(ctx context.Context, doc *html.Node, page crawlItem, opts mirrorOptions) []crawlItem {
+	base, found := utils.ExtractHTMLLinks(doc)
+	baseURL := page.url
+	if base != "" {
+		baseURL = utils.ResolveURL(page.url, base)
 	}
+	var links []crawlItem
+	for _, link := range found {
+		item := crawlItem{url: opts.resolve(baseURL, link.URL), depth: page.depth + 1, page: link.Page}
+		if app.follow(ctx, item, opts) {
+			links = append(links, item)
+		}
+	}
+	return links
+}
 
-	if utils.IsRejected(fileURL, rejectTypes) {
-		fmt.Printf("Skipping rejected file: %s\n", fileURL)
-		return
+// follow applies the mirror's filters to a link, logging why it is skipped.
+// With --page-requisites the assets of a page are fetched from any host not
+// excluded and regardless of --level; only stylesheets among them are read
+// for further links.
+func (app *AppState) follow(ctx context.Context, item crawlItem, opts mirrorOptions) bool {
+	host, err := utils.ExtractDomain(item.url)
+	if err != nil || host == "" {
+		return false
+	}
+	requisite := opts.requisites && !item.page
+	if requisite {
+		if domainListed(strings.ToLower(host), opts.excludeDomains) {
+			return false
+		}
+	} else if !opts.hostAllowed(host) {
+		return false
+	}
+	if !opts.filter.AllowDir(item.url) {
+		fmt.Printf("Skipping Rejected file path: %s\n", item.url)
+		return false
+	}
+	// HTML pages are still crawled when the lists reject their name, and
+	// removed once their links are read
+	crawlOnly := item.page && utils.LooksLikeHTML(item.url) && opts.filter.AllowRegex(item.url)
+	if !crawlOnly && !opts.filter.AllowName(item.url) {
+		fmt.Printf("Skipping rejected file: %s\n", item.url)
+		return false
+	}
+	if ok, reason := app.limits.admit(item.url, item.depth); !ok && !requisite {
+		fmt.Printf("Skipping %s (%s)\n", utils.RedactURL(item.url), reason)
+		return false
 	}
-	fmt.Printf("Downloading: %s\n", fileURL)
-	app.mirrorAsyncDownload("", fileURL, domain)
+	return app.robotsAllowed(ctx, item.url)
 }
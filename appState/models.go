@@ -1,21 +1,108 @@
 package appState
 
 import (
+	"net/http"
+	"regexp"
 	"sync"
+	"time"
+	"wget/utils"
 )
 
 // UrlArgs struct with exported fields (Uppercase names)
 type UrlArgs struct {
-	url              string
-	file             string
-	rateLimit        string
-	path             string
-	sourceFile       string
-	workInBackground bool
-	mirroring        bool
-	rejectFlag       string
-	excludeFlag      string
-	convertLinksFlag bool
+	url                string
+	file               string
+	rateLimit          string
+	path               string
+	sourceFile         string
+	workInBackground   bool
+	mirroring          bool
+	rejectFlag         string
+	excludeFlag        string
+	convertLinksFlag   bool
+	backupConverted    bool
+	adjustExtension    bool
+	contentDisposition bool
+	noClobber          bool
+	timestamping       bool
+	backups            int
+	convertDir         string // mirror directory for the convert-links subcommand
+	continueDownload   bool
+	segments           int
+	tries              string
+	waitRetry          string
+	retryOnHTTPError   string
+	connectTimeout     string
+	readTimeout        string
+	timeout            string
+	maxIdlePerHost     string
+	proxy              string
+	request            utils.RequestOptions
+	bodyData           string
+	bodyFile           string
+	credentials        utils.Credentials
+	askPassword        bool
+	loadCookies        string
+	saveCookies        string
+	keepSession        bool
+	checksum           *utils.Checksum
+	jobs               int
+	maxPerHost         int
+	failedList         string
+	robotsOff          bool
+	level              int
+	quota              int64
+	maxPages           int
+	noParent           bool
+	wait               time.Duration
+	spanHosts          bool
+	domains            string
+	excludeDomains     string
+	pageRequisites     bool
+	acceptFlag         string
+	includeFlag        string
+	acceptRegex        *regexp.Regexp
+	rejectRegex        *regexp.Regexp
+	acceptTypes        string
+	rejectTypes        string
+	stripParams        string
+}
+
+// AuthHosts tracks which hosts may receive credentials and caches their
+// ~/.netrc entries.
+type AuthHosts struct {
+	sync.Mutex
+	hosts map[string]bool
+	netrc map[string]utils.Credentials
+}
+
+// RunSummary records how each download of the run ended, for the report
+// printed when the run is interrupted.
+type RunSummary struct {
+	sync.Mutex
+	completed []string
+	partial   []string
+}
+
+// RobotsCache holds the robots.txt rules of every host seen while mirroring,
+// along with when the next request to each host may go out.
+type RobotsCache struct {
+	sync.Mutex
+	hosts map[string]*hostRobots
+}
+
+type hostRobots struct {
+	once   sync.Once
+	robots *utils.Robots
+	mu     sync.Mutex
+	next   time.Time // earliest time the Crawl-delay allows another request
+}
+
+// OutputPaths holds the files the downloads in flight are writing, so two
+// -i entries that derive the same name do not share a part file.
+type OutputPaths struct {
+	sync.Mutex
+	paths map[string]bool
 }
 
 type ProcessedURLs struct {
@@ -25,25 +112,44 @@ type ProcessedURLs struct {
 
 // AppState encapsulates global variables and synchronization primitives
 type AppState struct {
-	urlArgs           UrlArgs
+	urlArgs UrlArgs
 	// rateLimitedReader RateLimitedReader
-	processedURLs     ProcessedURLs
-	visitedPages      map[string]bool
-	visitedAssets     map[string]bool
-	muPages           sync.Mutex
-	muAssets          sync.Mutex
-	semaphore         chan struct{}
-	count             int
-	tempConfigFile    string
+	processedURLs  ProcessedURLs
+	tempConfigFile string
+	retry          utils.RetryPolicy
+	client         *http.Client
+	cookies        *utils.CookieJar
+	auth           AuthHosts
+	summary        RunSummary
+	outputs        OutputPaths
+	robots         RobotsCache
+	limits         CrawlLimits
 }
 
 func newAppstate() *AppState {
+	cookies, _ := utils.NewCookieJar()
 	return &AppState{
-		visitedPages: make(map[string]bool),
-		visitedAssets: make(map[string]bool),
 		processedURLs: ProcessedURLs{
 			urls: make(map[string]bool),
 		},
 		tempConfigFile: "progress_config.txt",
+		retry:          utils.DefaultRetryPolicy(),
+		client:         defaultClient(cookies),
+		cookies:        cookies,
+		auth: AuthHosts{
+			hosts: make(map[string]bool),
+			netrc: make(map[string]utils.Credentials),
+		},
+		outputs: OutputPaths{paths: make(map[string]bool)},
+		robots:  RobotsCache{hosts: make(map[string]*hostRobots)},
 	}
-}
\ No newline at end of file
+}
+
+// defaultClient builds the shared client from the default options, which are
+// always valid.
+func defaultClient(jar http.CookieJar) *http.Client {
+	opts := utils.DefaultClientOptions()
+	opts.Jar = jar
+	client, _ := utils.NewHttpClient(opts)
+	return client
+}
@@ -1,103 +1,281 @@
 package appState
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 	"wget/utils"
 )
 
-func (app *AppState) downloadMultipleFiles(filePath, outputFile, limit, directory string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("error opening file:\n%v", err)
+// defaultJobs is the number of -i downloads run at once when --jobs is not
+// given.
+const defaultJobs = 5
+
+// downloadMultipleFiles feeds the entries listed in filePath, or on stdin when
+// filePath is "-", to a fixed pool of --jobs workers. --max-per-host
+// additionally limits how many of them may talk to the same host at once.
+// Once every URL is done a table of results is printed, and the returned
+// error reports how many of them failed.
+func (app *AppState) downloadMultipleFiles(ctx context.Context, filePath, limit, directory string) error {
+	var input io.Reader = os.Stdin
+	if filePath != "-" {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("error opening file:\n%w", err)
+		}
+		defer file.Close()
+		input = file
 	}
-	defer file.Close()
 
-	var wg sync.WaitGroup
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		url := strings.TrimSpace(scanner.Text())
+	workers := app.urlArgs.jobs
+	if workers < 1 {
+		workers = defaultJobs
+	}
+	hosts := utils.NewHostLimiter(app.urlArgs.maxPerHost)
+	jobs := make(chan downloadJob)
+	// Workers signal here after freeing a host slot, so entries held back
+	// for a busy host get another chance
+	released := make(chan struct{}, 1)
+	var results batchResults
 
-		if url == "" {
-			continue // Skip empty lines
-		}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go func(url string) error {
+		go func() {
 			defer wg.Done()
-			err := app.AsyncDownload(outputFile, url, limit, directory)
+			for job := range jobs {
+				start := time.Now()
+				result, err := app.downloadEntry(ctx, job, limit, directory)
+				hosts.Release(job.host())
+				select {
+				case released <- struct{}{}:
+				default:
+				}
+				result.line, result.url = job.line, job.url
+				result.duration, result.err = time.Since(start), err
+				if err != nil {
+					fmt.Printf("%s\n", err)
+				}
+				results.add(result)
+			}
+		}()
+	}
+
+	// Entries whose host is at --max-per-host wait here instead of holding
+	// a worker, while entries for other hosts further down the list go ahead
+	list := newInputList(input)
+	var waiting []downloadJob
+	listDone := false
+	ready := func() (downloadJob, bool) {
+		for i, job := range waiting {
+			if hosts.TryAcquire(job.host()) {
+				waiting = slices.Delete(waiting, i, i+1)
+				return job, true
+			}
+		}
+		for !listDone {
+			job, err := list.next()
+			if err == io.EOF {
+				listDone = true
+				break
+			}
 			if err != nil {
-				return err
+				fmt.Printf("skipping entry of %s:\n%v\n", filePath, err)
+				if job.url != "" {
+					results.add(downloadResult{line: job.line, url: job.url, err: &utils.ExitError{Code: utils.ExitParse, Err: err}})
+				}
+				continue
+			}
+			app.authorizeHost(job.url)
+			if hosts.TryAcquire(job.host()) {
+				return job, true
 			}
-			return nil
-		}(url)
+			waiting = append(waiting, job)
+		}
+		return downloadJob{}, false
+	}
+
+	// Stop queueing new downloads once the run is cancelled
+	for ctx.Err() == nil {
+		job, ok := ready()
+		if !ok {
+			if listDone && len(waiting) == 0 {
+				break
+			}
+			select {
+			case <-released:
+			case <-ctx.Done():
+			}
+			continue
+		}
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			hosts.Release(job.host())
+			waiting = append(waiting, job)
+		}
 	}
+	// Entries that never started are reported as interrupted, so they show
+	// up in the table and in --failed-list
+	if err := ctx.Err(); err != nil {
+		interrupted := &utils.ExitError{Code: utils.ExitInterrupted, Err: fmt.Errorf("interrupted before the download started:\n%w", err)}
+		for _, job := range waiting {
+			results.add(downloadResult{line: job.line, url: job.url, err: interrupted})
+		}
+		for !listDone {
+			job, err := list.next()
+			if err == io.EOF {
+				break
+			}
+			if job.url != "" {
+				results.add(downloadResult{line: job.line, url: job.url, err: interrupted})
+			}
+		}
+	}
+	close(jobs)
 	wg.Wait()
 
-	return nil
+	results.print()
+	if err := list.err(); err != nil {
+		return err
+	}
+	if app.urlArgs.failedList != "" {
+		if err := results.writeFailedList(app.urlArgs.failedList); err != nil {
+			return err
+		}
+	}
+	return results.err()
+}
+
+// host returns the host of the entry's URL, which --max-per-host counts
+// downloads by.
+func (job downloadJob) host() string {
+	host, _ := utils.ExtractDomain(job.url)
+	return host
+}
+
+// downloadEntry downloads one entry of an -i file, applying its own options
+// on top of the ones given on the command line.
+func (app *AppState) downloadEntry(ctx context.Context, job downloadJob, limit, directory string) (downloadResult, error) {
+	if job.directory != "" {
+		if filepath.IsAbs(job.directory) || strings.HasPrefix(job.directory, "~") {
+			directory = job.directory
+		} else {
+			directory = filepath.Join(directory, job.directory)
+		}
+	}
+	if job.rateLimit != "" {
+		limit = job.rateLimit
+	}
+	return app.AsyncDownload(withEntryHeaders(ctx, job.headers), job.output, job.url, limit, directory, job.checksum)
 }
 
-func (app *AppState) AsyncDownload(outputFileName, url, limit, directory string) error {
+// AsyncDownload downloads one URL of an -i file. The returned result carries
+// the response status and the number of bytes received, even on failure.
+func (app *AppState) AsyncDownload(ctx context.Context, outputFileName, url, limit, directory string, checksum *utils.Checksum) (downloadResult, error) {
+	var result downloadResult
 	path, err := utils.ExpandPath(directory)
 	if err != nil {
-		return err
+		return result, err
 	}
 
-	resp, err := utils.HttpRequest(url)
-	if err != nil {
-		return err
+	source := nameGiven
+	if outputFileName == "" {
+		outputFileName = app.outputName(ctx, url)
+		source = nameFromURL
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: status %s url:\n[%s]", resp.Status, url)
+	outputFileName = filepath.Join(path, outputFileName)
+
+	plan := app.planOutput(outputFileName, source)
+	if plan.skip {
+		result.status = "skipped"
+		return result, nil
 	}
+	defer func() { app.releaseOutput(plan) }()
+	named := outputFileName
+	outputFileName = plan.path
 
-	if outputFileName == "" {
-		urlParts := strings.Split(url, "/")
-		fileName := urlParts[len(urlParts)-1]
-		outputFileName = filepath.Join(path, fileName)
-	} else {
-		outputFileName = filepath.Join(path, outputFileName)
+	var offset int64
+	if app.urlArgs.continueDownload {
+		offset = resumeOffset(outputFileName)
+	}
+
+	resp, err := app.fetch(withIfModifiedSince(ctx, plan.since), url, offset)
+	if err != nil {
+		return result, err
+	}
+	result.status = resp.Status
+	body := app.resumableBody(ctx, resp, url, offset)
+	defer body.Close()
+	// A name the server only gives with the download replaces the URL's
+	if name := app.responseName(resp); source == nameFromURL && offset == 0 && name != "" && filepath.Join(path, name) != named {
+		app.releaseOutput(plan)
+		if plan = app.planOutput(filepath.Join(path, name), source); plan.skip {
+			result.status = "skipped"
+			return result, nil
+		}
+		outputFileName = plan.path
+	}
+	if upToDate(plan, resp) {
+		return result, nil
+	}
+	if alreadyRetrieved(resp, offset) {
+		fmt.Printf("Already retrieved [%s]\n", utils.RedactURL(url))
+		return result, finishRetrieved(outputFileName)
+	}
+	if !checkResumeStatus(resp, offset) {
+		return result, &utils.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, URL: url}
 	}
 
 	if path != "" {
 		err = os.MkdirAll(path, 0o755)
 		if err != nil {
-			return fmt.Errorf("error creating directory:\n%v", err)
+			return result, fmt.Errorf("error creating directory:\n%w", err)
 		}
 	}
 
-	var out *os.File
-	out, err = os.Create(outputFileName)
+	out, offset, err := openOutput(outputFileName, offset, resp)
 	if err != nil {
-		return fmt.Errorf("error creating file:\n%v", err)
+		return result, err
 	}
-	defer out.Close()
+	defer app.settle(out)
+	out.backups, out.modTime = app.urlArgs.backups, lastModified(resp)
 
-	var reader io.Reader = resp.Body
+	verifier, err := newVerifier(checksum, out.Name(), offset)
+	if err != nil {
+		return result, err
+	}
+
+	var reader io.Reader = body
 	if limit != "" {
-		reader = utils.NewRateLimitedReader(resp.Body, limit)
+		reader = utils.NewRateLimitedReader(body, limit)
 	}
 
 	buffer := make([]byte, 32*1024)
-	fmt.Printf("Downloading.... [%s]\n", url)
-	var downloaded int64
+	fmt.Printf("Downloading.... [%s]\n", utils.RedactURL(url))
+	downloaded := offset
 	for {
 		n, err := reader.Read(buffer)
 		if err != nil && err != io.EOF {
-			return fmt.Errorf("oops! error reading response body")
+			out.readFailed(err)
+			return result, fmt.Errorf("oops! error reading response body:\n%w", err)
 		}
 
 		if n > 0 {
 			if _, err := out.Write(buffer[:n]); err != nil {
-				return fmt.Errorf("error writing to file:\n%v", err)
+				return result, fmt.Errorf("error writing to file:\n%w", err)
+			}
+			if verifier != nil {
+				verifier.Write(buffer[:n])
 			}
 			downloaded += int64(n)
+			result.bytes += int64(n)
 		}
 
 		if err == io.EOF {
@@ -105,8 +283,15 @@ func (app *AppState) AsyncDownload(outputFileName, url, limit, directory string)
 		}
 	}
 
+	if err := verifyDownload(checksum, verifier, out); err != nil {
+		return result, err
+	}
+	if err := out.commit(); err != nil {
+		return result, err
+	}
+
 	// endTime := time.Now()
-	fmt.Printf("\033[32mDownloaded\033[0m [%s]\n", url)
+	fmt.Printf("\033[32mDownloaded\033[0m [%s]\n", utils.RedactURL(url))
 
-	return nil
+	return result, nil
 }
@@ -0,0 +1,130 @@
+package appState
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"wget/utils"
+)
+
+// partSuffix marks a download that is still in progress.
+const partSuffix = ".part"
+
+// partFile is a download being written to final+".part" in the same
+// directory. It only appears under its final name once commit has synced
+// and renamed it, so an interrupted run never leaves a truncated file that
+// looks complete.
+type partFile struct {
+	*os.File
+	final string
+	// resumable keeps the part file on failure so --continue can pick it up
+	resumable bool
+	// restore moves the part file back to final on failure, as it was the
+	// user's file at final before --continue resumed it
+	restore   bool
+	committed bool
+	done      bool
+	// backups is --backups, the number of older copies of final to keep
+	backups int
+	// modTime is the server's Last-Modified, given to the file on commit
+	modTime time.Time
+}
+
+func partPath(final string) string {
+	return final + partSuffix
+}
+
+// createPart creates or truncates the part file for final.
+func createPart(final string) (*partFile, error) {
+	file, err := os.Create(partPath(final))
+	if err != nil {
+		return nil, fmt.Errorf("error creating file:\n%w", err)
+	}
+	return &partFile{File: file, final: final}, nil
+}
+
+// appendPart opens an existing part file for final to continue writing at
+// its end.
+func appendPart(final string) (*partFile, error) {
+	file, err := os.OpenFile(partPath(final), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file:\n%w", err)
+	}
+	return &partFile{File: file, final: final}, nil
+}
+
+// commit flushes the part file to disk and renames it to its final name.
+func (p *partFile) commit() error {
+	if err := p.File.Sync(); err != nil {
+		return fmt.Errorf("error writing to file:\n%w", err)
+	}
+	if err := p.File.Close(); err != nil {
+		return fmt.Errorf("error writing to file:\n%w", err)
+	}
+	if p.backups > 0 {
+		if err := rotateBackups(p.final, p.backups); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(p.Name(), p.final); err != nil {
+		return fmt.Errorf("error renaming %s:\n%w", p.Name(), err)
+	}
+	p.committed, p.done = true, true
+	// -N compares against this time on the next run
+	if !p.modTime.IsZero() {
+		os.Chtimes(p.final, p.modTime, p.modTime)
+	}
+	return nil
+}
+
+// finish is deferred by every downloader. When the download was not
+// committed it closes the part file and removes it, unless the failure left
+// it in a state that --continue can resume from or it holds a file resumed
+// from its final name, which is put back there.
+func (p *partFile) finish() {
+	if p.done {
+		return
+	}
+	p.File.Close()
+	if p.restore {
+		os.Rename(p.Name(), p.final)
+	} else if !p.resumable {
+		os.Remove(p.Name())
+	}
+}
+
+// readFailed records why reading the response body failed; transient network
+// errors keep the part file for a later --continue.
+func (p *partFile) readFailed(err error) {
+	p.resumable = utils.IsRetryableError(err) || errors.Is(err, context.Canceled)
+}
+
+// settle is deferred by every downloader: it finishes out and records the
+// outcome in the run summary.
+func (app *AppState) settle(out *partFile) {
+	out.finish()
+	app.summary.Lock()
+	defer app.summary.Unlock()
+	if out.committed {
+		app.summary.completed = append(app.summary.completed, out.final)
+	} else if out.restore {
+		app.summary.partial = append(app.summary.partial, out.final)
+	} else if out.resumable {
+		app.summary.partial = append(app.summary.partial, out.Name())
+	}
+}
+
+// print reports what completed before the run was interrupted.
+func (s *RunSummary) print() {
+	s.Lock()
+	defer s.Unlock()
+	fmt.Printf("\ninterrupted: %d file(s) completed, %d partial file(s) kept\n", len(s.completed), len(s.partial))
+	for _, file := range s.completed {
+		fmt.Printf("  completed: %s\n", file)
+	}
+	for _, file := range s.partial {
+		fmt.Printf("  partial:   %s (resume with --continue)\n", file)
+	}
+}
@@ -0,0 +1,96 @@
+package appState
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"wget/utils"
+)
+
+// resumeOffset returns how many bytes of final are already on disk. They are
+// normally in final's part file; a file left at the final name by another
+// tool is resumed in the same way, but only moved to the part file by
+// openOutput once the server has agreed to resume it. It returns 0 when
+// there is nothing to resume from.
+func resumeOffset(final string) int64 {
+	if info, err := os.Stat(partPath(final)); err == nil && !info.IsDir() {
+		return info.Size()
+	}
+	if info, err := os.Stat(final); err == nil && !info.IsDir() {
+		return info.Size()
+	}
+	return 0
+}
+
+// alreadyRetrieved reports whether the server rejected a resume request
+// because the partial file already holds the whole resource.
+func alreadyRetrieved(resp *http.Response, offset int64) bool {
+	if offset == 0 || resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		return false
+	}
+	// "bytes */total" is the only form allowed on a 416 reply
+	var total int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes */%d", &total); err != nil {
+		return false
+	}
+	return total == offset
+}
+
+// openOutput opens the part file for a response that was requested from
+// offset. A 206 reply is validated against Content-Range and appended to the
+// existing part file, which is first moved there from final when that is
+// where the bytes are; a 200 reply means the server ignored the range, so a
+// new part file is written from byte zero and final is left alone until the
+// download is committed.
+func openOutput(final string, offset int64, resp *http.Response) (*partFile, int64, error) {
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		start, _, _, err := utils.ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("error resuming download:\n%w", err)
+		}
+		if start != offset {
+			return nil, 0, fmt.Errorf("error resuming download:\nserver resumed at byte %d, expected %d", start, offset)
+		}
+		restore := false
+		if !utils.FileExists(partPath(final)) {
+			if err := os.Rename(final, partPath(final)); err != nil {
+				return nil, 0, fmt.Errorf("error resuming download:\n%w", err)
+			}
+			restore = true
+		}
+		out, err := appendPart(final)
+		if err != nil {
+			if restore {
+				os.Rename(partPath(final), final)
+			}
+			return nil, 0, err
+		}
+		out.restore = restore
+		return out, offset, nil
+	}
+
+	out, err := createPart(final)
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, 0, nil
+}
+
+// finishRetrieved moves a part file that the server reported as complete to
+// its final name. A file resumed from its final name is already there.
+func finishRetrieved(final string) error {
+	if !utils.FileExists(partPath(final)) {
+		return nil
+	}
+	if err := os.Rename(partPath(final), final); err != nil {
+		return fmt.Errorf("error renaming %s:\n%w", partPath(final), err)
+	}
+	return nil
+}
+
+// checkResumeStatus accepts 200 for every request and 206 for requests made
+// with a non-zero offset.
+func checkResumeStatus(resp *http.Response, offset int64) bool {
+	return resp.StatusCode == http.StatusOK ||
+		(offset > 0 && resp.StatusCode == http.StatusPartialContent)
+}
@@ -0,0 +1,106 @@
+package appState
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"wget/utils"
+)
+
+// maxRobotsSize is how much of a robots.txt file is read, the minimum RFC
+// 9309 asks crawlers to parse.
+const maxRobotsSize = 500 << 10
+
+// hostEntry returns the entry for the scheme and host of u.
+func (app *AppState) hostEntry(u *url.URL) *hostRobots {
+	origin := u.Scheme + "://" + u.Host
+	app.robots.Lock()
+	defer app.robots.Unlock()
+	entry, ok := app.robots.hosts[origin]
+	if !ok {
+		entry = &hostRobots{}
+		app.robots.hosts[origin] = entry
+	}
+	return entry
+}
+
+// robotsFor returns the entry for the scheme and host of u, fetching its
+// robots.txt the first time it is needed.
+func (app *AppState) robotsFor(ctx context.Context, u *url.URL) *hostRobots {
+	entry := app.hostEntry(u)
+	entry.once.Do(func() {
+		entry.robots = app.fetchRobots(ctx, u.Scheme+"://"+u.Host)
+	})
+	return entry
+}
+
+// fetchRobots downloads and parses origin/robots.txt. A missing file allows
+// everything; a server error or an unreachable host disallows everything, as
+// RFC 9309 asks.
+func (app *AppState) fetchRobots(ctx context.Context, origin string) *utils.Robots {
+	robotsURL := origin + "/robots.txt"
+	resp, err := app.fetch(ctx, robotsURL, 0)
+	if err != nil {
+		fmt.Printf("could not fetch %s, treating the host as disallowed:\n%v\n", utils.RedactURL(robotsURL), err)
+		return utils.DisallowAll
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return utils.ParseRobots(io.LimitReader(resp.Body, maxRobotsSize))
+	case resp.StatusCode >= 500:
+		fmt.Printf("%s returned %s, treating the host as disallowed\n", utils.RedactURL(robotsURL), resp.Status)
+		return utils.DisallowAll
+	default:
+		return utils.AllowAll
+	}
+}
+
+// robotsAllowed reports whether robots.txt lets the mirror fetch rawURL,
+// logging the URLs it skips. --robots=off allows everything.
+func (app *AppState) robotsAllowed(ctx context.Context, rawURL string) bool {
+	if app.urlArgs.robotsOff {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return true
+	}
+	if !app.robotsFor(ctx, u).robots.Allowed(utils.RobotsAgent, rawURL) {
+		fmt.Printf("Skipping %s (disallowed by robots.txt)\n", utils.RedactURL(rawURL))
+		return false
+	}
+	return true
+}
+
+// politeWait blocks until requests to rawURL's host have been spaced out by
+// --wait, or by the host's Crawl-delay when that is longer. Concurrent callers
+// each reserve their own slot, so the spacing holds across workers.
+func (app *AppState) politeWait(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	delay := app.urlArgs.wait
+	entry := app.hostEntry(u)
+	if !app.urlArgs.robotsOff {
+		delay = max(delay, app.robotsFor(ctx, u).robots.CrawlDelay(utils.RobotsAgent))
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	entry.mu.Lock()
+	slot := time.Now()
+	if entry.next.After(slot) {
+		slot = entry.next
+	}
+	entry.next = slot.Add(delay)
+	entry.mu.Unlock()
+	return utils.Sleep(ctx, time.Until(slot))
+}
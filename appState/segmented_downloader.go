@@ -0,0 +1,253 @@
+package appState
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wget/utils"
+)
+
+// segment is one byte range of a segmented download. done counts the bytes
+// already written so a retry can continue from start+done.
+type segment struct {
+	start, end int64
+	done       int64
+}
+
+// segmentedDownloader fetches url over several concurrent range requests and
+// writes each range into its place in a preallocated file. Servers that do not
+// advertise byte ranges or a content length fall back to singleDownloader.
+func (app *AppState) segmentedDownloader(ctx context.Context, file, url, limit, directory string, segments int, checksum *utils.Checksum) error {
+	path, err := utils.ExpandPath(directory)
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	name, source := file, nameGiven
+	if name == "" {
+		name, source = app.outputName(ctx, url), nameFromURL
+	}
+	plan := app.planOutput(filepath.Join(path, name), source)
+	if plan.skip {
+		return nil
+	}
+	defer func() { app.releaseOutput(plan) }()
+
+	headCtx := withIfModifiedSince(ctx, plan.since)
+	head, err := app.retry.Do(ctx, func() (*http.Response, error) {
+		return utils.HttpHeadRequest(headCtx, app.client, app.contextOptions(headCtx, url), url)
+	})
+	if err != nil {
+		return fmt.Errorf("error downloading file:\nserver misbehaving: %w", err)
+	}
+	head.Body.Close()
+	// A name the server only gives in its reply replaces the URL's
+	if suggested := app.responseName(head); source == nameFromURL && suggested != "" && suggested != name {
+		app.releaseOutput(plan)
+		if plan = app.planOutput(filepath.Join(path, suggested), source); plan.skip {
+			return nil
+		}
+	}
+	if upToDate(plan, head) {
+		return nil
+	}
+
+	contentLength := head.ContentLength
+	if head.StatusCode != http.StatusOK || head.Header.Get("Accept-Ranges") != "bytes" || contentLength < int64(segments) {
+		fmt.Println("server does not support byte ranges, downloading in a single stream")
+		// The single stream plans the name again
+		app.releaseOutput(plan)
+		plan.reserved = false
+		return app.singleDownloader(ctx, file, url, limit, directory, checksum)
+	}
+
+	toDisplay, err := utils.LoadShowProgressState(app.tempConfigFile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("started at %s\n", startTime.Format("2006-01-02 15:04:05"))
+	fmt.Printf("sending request, awaiting response... status %s\n", head.Status)
+	fmt.Printf("content size: %d bytes [~%.2fMB]\n", contentLength, float64(contentLength)/1000000)
+
+	outputFile := plan.path
+	if path != "" {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return fmt.Errorf("oops! error creating path\n%w", err)
+		}
+	}
+	fmt.Printf("saving file to: %s\n", outputFile)
+
+	// Segments cannot be resumed, so a failed run always discards the part file
+	out, err := createPart(outputFile)
+	if err != nil {
+		return err
+	}
+	defer app.settle(out)
+	out.backups, out.modTime = app.urlArgs.backups, lastModified(head)
+	if err := out.Truncate(contentLength); err != nil {
+		return fmt.Errorf("error preallocating file:\n%w", err)
+	}
+
+	// All segments draw from one bucket so --rate-limit stays a global budget
+	var limiter *utils.RateLimiter
+	if limit != "" {
+		limiter = utils.NewRateLimiter(limit)
+	}
+
+	var downloaded atomic.Int64
+	stop := make(chan struct{})
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		if !toDisplay {
+			<-stop
+			return
+		}
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				app.showProgress(downloaded.Load(), contentLength, startTime)
+				fmt.Println()
+				return
+			case <-ticker.C:
+				app.showProgress(downloaded.Load(), contentLength, startTime)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, segments)
+	attempts := make([]int, segments)
+	for i, seg := range splitSegments(contentLength, segments) {
+		wg.Add(1)
+		go func(i int, seg *segment) {
+			defer wg.Done()
+			// Each segment is retried on its own, continuing from its last
+			// byte, so --tries counts every request made for it
+			for attempts[i] = 1; ; attempts[i]++ {
+				errs[i] = app.fetchSegment(ctx, out, url, seg, limiter, &downloaded)
+				if errs[i] == nil || !app.retrySegment(errs[i]) || attempts[i] >= app.retry.Tries || ctx.Err() != nil {
+					return
+				}
+				if utils.Sleep(ctx, app.retry.Backoff(attempts[i])) != nil {
+					return
+				}
+			}
+		}(i, seg)
+	}
+	wg.Wait()
+	close(stop)
+	<-progressDone
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("error downloading segment %d after %d attempts:\n%w", i+1, attempts[i], err)
+		}
+	}
+
+	// Segments arrive out of order, so the finished file is hashed from disk
+	if err := verifyFile(checksum, out); err != nil {
+		return err
+	}
+	if err := out.commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Downloaded [%s]\n", utils.RedactURL(url))
+	fmt.Printf("finished at %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// retrySegment reports whether a failed segment request is worth repeating:
+// a transient network failure, including a transfer cut short, or a status
+// the retry policy retries.
+func (app *AppState) retrySegment(err error) bool {
+	var statusErr *utils.StatusError
+	if errors.As(err, &statusErr) {
+		return app.retry.IsRetryableStatus(statusErr.StatusCode)
+	}
+	return utils.IsRetryableError(err)
+}
+
+// splitSegments divides size bytes into n contiguous ranges, giving any
+// remainder to the last one.
+func splitSegments(size int64, n int) []*segment {
+	segs := make([]*segment, n)
+	chunk := size / int64(n)
+	for i := range segs {
+		start := int64(i) * chunk
+		end := start + chunk - 1
+		if i == n-1 {
+			end = size - 1
+		}
+		segs[i] = &segment{start: start, end: end}
+	}
+	return segs
+}
+
+// fetchSegment downloads the remaining part of seg and writes it at its
+// offset in out, adding every written byte to downloaded. It sends a single
+// request; retrying is left to the caller.
+func (app *AppState) fetchSegment(ctx context.Context, out *partFile, url string, seg *segment, limiter *utils.RateLimiter, downloaded *atomic.Int64) error {
+	from := seg.start + seg.done
+	if from > seg.end {
+		return nil
+	}
+
+	resp, err := utils.HttpSegmentRequest(ctx, app.client, app.contextOptions(ctx, url), url, from, seg.end)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return &utils.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, URL: url}
+	}
+	start, _, _, err := utils.ParseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if start != from {
+		return fmt.Errorf("server returned range starting at %d, expected %d", start, from)
+	}
+
+	var reader io.Reader = resp.Body
+	if limiter != nil {
+		reader = utils.NewSharedRateLimitedReader(resp.Body, limiter)
+	}
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			remaining := seg.end - (seg.start + seg.done) + 1
+			if int64(n) > remaining {
+				n = int(remaining)
+			}
+			if _, err := out.WriteAt(buffer[:n], seg.start+seg.done); err != nil {
+				return fmt.Errorf("error writing to file\n%w", err)
+			}
+			seg.done += int64(n)
+			downloaded.Add(int64(n))
+		}
+		if seg.start+seg.done > seg.end {
+			return nil
+		}
+		if err == io.EOF {
+			return fmt.Errorf("connection closed after %d of %d bytes:\n%w", seg.done, seg.end-seg.start+1, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading response body\n%w", err)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package appState
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,7 +11,7 @@ import (
 	"wget/utils"
 )
 
-func (app *AppState) singleDownloader(file, url, limit, directory string) error {
+func (app *AppState) singleDownloader(ctx context.Context, file, url, limit, directory string, checksum *utils.Checksum) error {
 	path, err := utils.ExpandPath(directory)
 	if err != nil {
 		return err
@@ -25,36 +25,91 @@ func (app *AppState) singleDownloader(file, url, limit, directory string) error
 	}
 	fmt.Printf("started at %s\n", startTime.Format("2006-01-02 15:04:05"))
 
-	resp, err := utils.HttpRequest(fileURL)
-	if err != nil {
-		return fmt.Errorf("error downloading file:\nserver misbehaving")
+	// Set the output file name
+	source := nameGiven
+	if file == "" {
+		file = app.outputName(ctx, fileURL)
+		source = nameFromURL
 	}
-	defer resp.Body.Close()
+	outputFile := filepath.Join(path, file)
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: status %s\nurl: [%s]", resp.Status, url)
+	// A file already on disk is kept, checked with -N, or saved beside
+	plan := app.planOutput(outputFile, source)
+	if plan.skip {
+		return nil
+	}
+	defer func() { app.releaseOutput(plan) }()
+	named := outputFile
+	file += strings.TrimPrefix(plan.path, outputFile)
+	outputFile = plan.path
+
+	// Pick up where a previous attempt left off
+	var offset int64
+	if app.urlArgs.continueDownload {
+		offset = resumeOffset(outputFile)
 	}
-	fmt.Printf("sending request, awaiting response... status %s\n", resp.Status)
 
-	contentLength := resp.ContentLength
-	fmt.Printf("content size: %d bytes [~%.2fMB]\n", contentLength, float64(contentLength)/1000000)
+	resp, err := app.fetch(withIfModifiedSince(ctx, plan.since), fileURL, offset)
+	if err != nil {
+		return fmt.Errorf("error downloading file:\nserver misbehaving: %w", err)
+	}
+	body := app.resumableBody(ctx, resp, fileURL, offset)
+	defer body.Close()
+
+	// A name the server only gives with the download replaces the URL's
+	if name := app.responseName(resp); source == nameFromURL && offset == 0 && name != "" && filepath.Join(path, name) != named {
+		outputFile = filepath.Join(path, name)
+		app.releaseOutput(plan)
+		if plan = app.planOutput(outputFile, source); plan.skip {
+			return nil
+		}
+		file = name + strings.TrimPrefix(plan.path, outputFile)
+		outputFile = plan.path
+	}
 
-	// Set the output file name
-	var outputFile string
-	if file == "" {
-		urlParts := strings.Split(fileURL, "/")
-		file = urlParts[len(urlParts)-1]
-		outputFile = filepath.Join(path, file)
-	} else {
-		outputFile = filepath.Join(path, file)
+	if upToDate(plan, resp) {
+		return nil
+	}
+
+	if alreadyRetrieved(resp, offset) {
+		fmt.Printf("the file is already fully retrieved; nothing to do.\n")
+		return finishRetrieved(outputFile)
 	}
+	if !checkResumeStatus(resp, offset) {
+		return &utils.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, URL: url}
+	}
+	fmt.Printf("sending request, awaiting response... status %s\n", resp.Status)
+
 	// Create the path if it doesn't exist
 	if path != "" {
 		err = os.MkdirAll(path, 0o755)
 		if err != nil {
-			return fmt.Errorf("oops! error creating path\n%v", err)
+			return fmt.Errorf("oops! error creating path\n%w", err)
 		}
 	}
+
+	out, offset, err := openOutput(outputFile, offset, resp)
+	if err != nil {
+		return err
+	}
+	defer app.settle(out)
+	out.backups, out.modTime = app.urlArgs.backups, lastModified(resp)
+
+	// Hash the bytes as they are written instead of rereading the file
+	verifier, err := newVerifier(checksum, out.Name(), offset)
+	if err != nil {
+		return err
+	}
+
+	contentLength := resp.ContentLength
+	if contentLength >= 0 {
+		contentLength += offset
+	}
+	fmt.Printf("content size: %d bytes [~%.2fMB]\n", contentLength, float64(contentLength)/1000000)
+	if offset > 0 {
+		fmt.Printf("resuming from byte %d\n", offset)
+	}
+
 	temp := ""
 	if file != "" && directory != "" {
 		fmt.Printf("saving file to: %s%s\n", directory, file)
@@ -66,21 +121,15 @@ func (app *AppState) singleDownloader(file, url, limit, directory string) error
 		fmt.Printf("saving file to: %s%s\n", temp, file)
 	}
 
-	out, err := os.Create(outputFile)
-	if err != nil {
-		return fmt.Errorf("error creating file:\n%v", err)
-	}
-	defer out.Close()
-
 	var reader io.Reader
 	if limit != "" {
-		reader = utils.NewRateLimitedReader(resp.Body, limit)
+		reader = utils.NewRateLimitedReader(body, limit)
 	} else {
-		reader = resp.Body
+		reader = body
 	}
 
 	buffer := make([]byte, 32*1024) // 32 KB buffer size
-	var downloaded int64
+	downloaded := offset
 	startDownload := time.Now()
 
 	if toDisplay {
@@ -89,12 +138,16 @@ func (app *AppState) singleDownloader(file, url, limit, directory string) error
 	for {
 		n, err := reader.Read(buffer)
 		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading response body\n%v", err)
+			out.readFailed(err)
+			return fmt.Errorf("error reading response body\n%w", err)
 		}
 
 		if n > 0 {
 			if _, err := out.Write(buffer[:n]); err != nil {
-				return fmt.Errorf("error writing to file\n%v", err)
+				return fmt.Errorf("error writing to file\n%w", err)
+			}
+			if verifier != nil {
+				verifier.Write(buffer[:n])
 			}
 			// Update the downloaded size
 			downloaded += int64(n)
@@ -102,7 +155,7 @@ func (app *AppState) singleDownloader(file, url, limit, directory string) error
 			if toDisplay {
 				// Calculate and display the progress
 				progress := float64(downloaded) / float64(contentLength) * 50
-				speed := float64(downloaded) / time.Since(startDownload).Seconds()
+				speed := float64(downloaded-offset) / time.Since(startDownload).Seconds()
 				timeRemaining := time.Duration(float64(contentLength-downloaded)/speed) * time.Second
 
 				// Update the same line with progress
@@ -120,7 +173,7 @@ func (app *AppState) singleDownloader(file, url, limit, directory string) error
 
 		}
 
-		if downloaded >= contentLength {
+		if err == io.EOF || (contentLength >= 0 && downloaded >= contentLength) {
 			break
 		}
 	}
@@ -129,8 +182,15 @@ func (app *AppState) singleDownloader(file, url, limit, directory string) error
 		fmt.Println()
 	}
 
+	if err := verifyDownload(checksum, verifier, out); err != nil {
+		return err
+	}
+	if err := out.commit(); err != nil {
+		return err
+	}
+
 	endTime := time.Now()
-	fmt.Printf("Downloaded [%s]\n", fileURL)
+	fmt.Printf("Downloaded [%s]\n", utils.RedactURL(fileURL))
 	fmt.Printf("finished at %s\n", endTime.Format("2006-01-02 15:04:05"))
 	if !toDisplay {
 		fmt.Println()
@@ -1,7 +1,11 @@
 package appState
 
 import (
+	"context"
+	"errors"
 	"sync"
+
+	"wget/utils"
 )
 
 // AppState holds global variables and synchronization mechanisms
@@ -11,14 +15,24 @@ var (
 	once     sync.Once
 )
 
-// GetAppState provides access to the Singleton instance of AppState
-func GetAppState() (*AppState, error) {
+// ErrInterrupted is returned when the run was cancelled by SIGINT or SIGTERM.
+var ErrInterrupted error = &utils.ExitError{Code: utils.ExitInterrupted, Err: errors.New("interrupted")}
+
+// GetAppState provides access to the Singleton instance of AppState. The
+// first call runs the requested task until it completes or ctx is cancelled.
+func GetAppState(ctx context.Context) (*AppState, error) {
 	var err error
 
 	once.Do(func() {
 		instance = newAppstate()
 		err = instance.parseArgs()
-		err = instance.taskManager(err)
+		if err != nil {
+			err = &utils.ExitError{Code: utils.ExitParse, Err: err}
+		}
+		err = instance.taskManager(ctx, err)
+		if ctx.Err() != nil {
+			err = ErrInterrupted
+		}
 	})
 
 	if err != nil {
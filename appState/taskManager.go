@@ -1,36 +1,68 @@
 package appState
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"wget/utils"
 )
 
 // taskManager calls to action methods depending on the passed flags
-func (app *AppState) taskManager(err error) error {
+func (app *AppState) taskManager(ctx context.Context, err error) error {
 	if err != nil {
 		return err
 	}
 
+	// An interrupted run reports which files finished and which can be
+	// resumed with --continue
+	defer func() {
+		if ctx.Err() != nil {
+			app.summary.print()
+		}
+	}()
+
+	// Write the cookie jar back out once all the work is done. Work handed to
+	// a background process is left to it, as it may still be reading the
+	// same file through --load-cookies
+	background := false
+	if app.urlArgs.saveCookies != "" {
+		defer func() {
+			if background {
+				return
+			}
+			if err := app.cookies.Save(app.urlArgs.saveCookies, app.urlArgs.keepSession); err != nil {
+				fmt.Println(err)
+			}
+		}()
+	}
+
+	// Only the URL given on the command line may receive credentials
+	if app.urlArgs.url != "" {
+		app.authorizeHost(app.urlArgs.url)
+	}
+
+	// Convert the links of an existing mirror without downloading anything
+	if app.urlArgs.convertDir != "" {
+		return app.convertMirrorDir(app.urlArgs.convertDir)
+	}
+
 	// Mirror website handling
 	if app.urlArgs.mirroring {
-		err := app.downloadAndMirror(app.urlArgs.url, app.urlArgs.rejectFlag, app.urlArgs.convertLinksFlag, app.urlArgs.excludeFlag)
+		err := app.downloadAndMirror(ctx, app.urlArgs.url, app.urlArgs.rejectFlag, app.urlArgs.convertLinksFlag, app.urlArgs.excludeFlag)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
 
-	// If no file name is provided, derive it from the url
-	if app.urlArgs.file == "" && app.urlArgs.url != "" {
-		urlParts := strings.Split(app.urlArgs.url, "/")
-		app.urlArgs.file = urlParts[len(urlParts)-1]
-	}
-
 	// Handle the work-in-background flag
 	if app.urlArgs.workInBackground {
+		background = true
 		err := app.downloadInBackground(app.urlArgs.file, app.urlArgs.url, app.urlArgs.rateLimit)
 		if err != nil {
 			return err
@@ -40,7 +72,7 @@ func (app *AppState) taskManager(err error) error {
 
 	// Handle multiple file downloads from sourceFile
 	if app.urlArgs.sourceFile != "" {
-		err := app.downloadMultipleFiles(app.urlArgs.sourceFile, app.urlArgs.file, app.urlArgs.rateLimit, app.urlArgs.path)
+		err := app.downloadMultipleFiles(ctx, app.urlArgs.sourceFile, app.urlArgs.rateLimit, app.urlArgs.path)
 		if err != nil {
 			return err
 		}
@@ -52,8 +84,13 @@ func (app *AppState) taskManager(err error) error {
 		return fmt.Errorf("error: url not provided")
 	}
 
+	// Split large downloads across several connections when asked to
+	if app.urlArgs.segments > 1 {
+		return app.segmentedDownloader(ctx, app.urlArgs.file, app.urlArgs.url, app.urlArgs.rateLimit, app.urlArgs.path, app.urlArgs.segments, app.urlArgs.checksum)
+	}
+
 	// Start downloading the file
-	err = app.singleDownloader(app.urlArgs.file, app.urlArgs.url, app.urlArgs.rateLimit, app.urlArgs.path)
+	err = app.singleDownloader(ctx, app.urlArgs.file, app.urlArgs.url, app.urlArgs.rateLimit, app.urlArgs.path, app.urlArgs.checksum)
 	if err != nil {
 		return err
 	}
@@ -62,6 +99,10 @@ func (app *AppState) taskManager(err error) error {
 
 // ParseArgs parses the command-line arguments and returns a urlArgs struct
 func (app *AppState) parseArgs() error {
+	if len(os.Args) > 1 && os.Args[1] == "convert-links" {
+		return app.parseConvertArgs(os.Args[2:])
+	}
+
 	mirrorMode := false
 	track := false
 
@@ -85,6 +126,25 @@ func (app *AppState) parseArgs() error {
 				return fmt.Errorf("error: --convert-links can only be used with --mirror")
 			}
 			app.urlArgs.convertLinksFlag = true
+		} else if arg == "-nc" || arg == "--no-clobber" {
+			app.urlArgs.noClobber = true
+		} else if arg == "-N" || arg == "--timestamping" {
+			app.urlArgs.timestamping = true
+		} else if strings.HasPrefix(arg, "--backups=") {
+			n, err := strconv.Atoi(arg[len("--backups="):])
+			if err != nil || n < 0 {
+				return fmt.Errorf("error: invalid --backups value '%s'", arg[len("--backups="):])
+			}
+			app.urlArgs.backups = n
+		} else if arg == "--content-disposition" {
+			app.urlArgs.contentDisposition = true
+		} else if arg == "-E" || arg == "--adjust-extension" {
+			if !mirrorMode {
+				return fmt.Errorf("error: --adjust-extension can only be used with --mirror")
+			}
+			app.urlArgs.adjustExtension = true
+		} else if arg == "-K" || arg == "--backup-converted" {
+			app.urlArgs.backupConverted = true
 		} else if strings.HasPrefix(arg, "-R=") || strings.HasPrefix(arg, "--reject=") {
 			if !mirrorMode {
 				return fmt.Errorf("error: --reject can only be used with --mirror")
@@ -94,15 +154,168 @@ func (app *AppState) parseArgs() error {
 			} else {
 				app.urlArgs.rejectFlag = arg[len("--reject="):]
 			}
-		} else if strings.HasPrefix(arg, "-X=") || strings.HasPrefix(arg, "--exclude=") {
+		} else if strings.HasPrefix(arg, "-X=") || strings.HasPrefix(arg, "--exclude=") || strings.HasPrefix(arg, "--exclude-directories=") {
 			if !mirrorMode {
 				return fmt.Errorf("error: --exclude can only be used with --mirror")
 			}
-			if strings.HasPrefix(arg, "-X=") {
-				app.urlArgs.excludeFlag = arg[len("-X="):]
+			_, app.urlArgs.excludeFlag, _ = strings.Cut(arg, "=")
+		} else if strings.HasPrefix(arg, "-A=") || strings.HasPrefix(arg, "--accept=") {
+			if !mirrorMode {
+				return fmt.Errorf("error: --accept can only be used with --mirror")
+			}
+			_, app.urlArgs.acceptFlag, _ = strings.Cut(arg, "=")
+		} else if strings.HasPrefix(arg, "-I=") || strings.HasPrefix(arg, "--include-directories=") {
+			if !mirrorMode {
+				return fmt.Errorf("error: --include-directories can only be used with --mirror")
+			}
+			_, app.urlArgs.includeFlag, _ = strings.Cut(arg, "=")
+		} else if strings.HasPrefix(arg, "--accept-regex=") || strings.HasPrefix(arg, "--reject-regex=") {
+			if !mirrorMode {
+				return fmt.Errorf("error: --accept-regex and --reject-regex can only be used with --mirror")
+			}
+			name, value, _ := strings.Cut(arg, "=")
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return fmt.Errorf("error: invalid %s:\n%v", name, err)
+			}
+			if name == "--accept-regex" {
+				app.urlArgs.acceptRegex = re
+			} else {
+				app.urlArgs.rejectRegex = re
+			}
+		} else if strings.HasPrefix(arg, "--accept-type=") || strings.HasPrefix(arg, "--reject-type=") {
+			if !mirrorMode {
+				return fmt.Errorf("error: --accept-type and --reject-type can only be used with --mirror")
+			}
+			if strings.HasPrefix(arg, "--accept-type=") {
+				app.urlArgs.acceptTypes = arg[len("--accept-type="):]
 			} else {
-				app.urlArgs.excludeFlag = arg[len("--exclude="):]
+				app.urlArgs.rejectTypes = arg[len("--reject-type="):]
+			}
+		} else if strings.HasPrefix(arg, "--strip-params=") {
+			if !mirrorMode {
+				return fmt.Errorf("error: --strip-params can only be used with --mirror")
 			}
+			app.urlArgs.stripParams = arg[len("--strip-params="):]
+		} else if strings.HasPrefix(arg, "--segments=") {
+			n, err := strconv.Atoi(arg[len("--segments="):])
+			if err != nil || n < 1 {
+				return fmt.Errorf("error: invalid segment count '%s'", arg[len("--segments="):])
+			}
+			app.urlArgs.segments = n
+		} else if strings.HasPrefix(arg, "--tries=") {
+			app.urlArgs.tries = arg[len("--tries="):]
+		} else if strings.HasPrefix(arg, "--waitretry=") {
+			app.urlArgs.waitRetry = arg[len("--waitretry="):]
+		} else if strings.HasPrefix(arg, "--retry-on-http-error=") {
+			app.urlArgs.retryOnHTTPError = arg[len("--retry-on-http-error="):]
+		} else if strings.HasPrefix(arg, "--connect-timeout=") {
+			app.urlArgs.connectTimeout = arg[len("--connect-timeout="):]
+		} else if strings.HasPrefix(arg, "--read-timeout=") {
+			app.urlArgs.readTimeout = arg[len("--read-timeout="):]
+		} else if strings.HasPrefix(arg, "--timeout=") {
+			app.urlArgs.timeout = arg[len("--timeout="):]
+		} else if strings.HasPrefix(arg, "--max-idle-per-host=") {
+			app.urlArgs.maxIdlePerHost = arg[len("--max-idle-per-host="):]
+		} else if strings.HasPrefix(arg, "--proxy=") {
+			app.urlArgs.proxy = arg[len("--proxy="):]
+		} else if strings.HasPrefix(arg, "--header=") {
+			if err := app.urlArgs.request.AddHeader(arg[len("--header="):]); err != nil {
+				return fmt.Errorf("error: %v", err)
+			}
+		} else if strings.HasPrefix(arg, "--user-agent=") {
+			app.urlArgs.request.UserAgent = arg[len("--user-agent="):]
+		} else if strings.HasPrefix(arg, "--referer=") {
+			app.urlArgs.request.Referer = arg[len("--referer="):]
+		} else if strings.HasPrefix(arg, "--method=") {
+			app.urlArgs.request.Method = strings.ToUpper(arg[len("--method="):])
+		} else if strings.HasPrefix(arg, "--body-data=") {
+			app.urlArgs.bodyData = arg[len("--body-data="):]
+		} else if strings.HasPrefix(arg, "--body-file=") {
+			app.urlArgs.bodyFile = arg[len("--body-file="):]
+		} else if strings.HasPrefix(arg, "--user=") {
+			app.urlArgs.credentials.User = arg[len("--user="):]
+		} else if strings.HasPrefix(arg, "--password=") {
+			app.urlArgs.credentials.Password = arg[len("--password="):]
+		} else if arg == "--ask-password" {
+			app.urlArgs.askPassword = true
+		} else if strings.HasPrefix(arg, "--bearer-token=") {
+			app.urlArgs.credentials.BearerToken = arg[len("--bearer-token="):]
+		} else if strings.HasPrefix(arg, "--load-cookies=") {
+			app.urlArgs.loadCookies = arg[len("--load-cookies="):]
+		} else if strings.HasPrefix(arg, "--save-cookies=") {
+			app.urlArgs.saveCookies = arg[len("--save-cookies="):]
+		} else if arg == "--keep-session-cookies" {
+			app.urlArgs.keepSession = true
+		} else if strings.HasPrefix(arg, "--checksum=") {
+			checksum, err := utils.ParseChecksum(arg[len("--checksum="):])
+			if err != nil {
+				return fmt.Errorf("error: %v", err)
+			}
+			app.urlArgs.checksum = checksum
+		} else if strings.HasPrefix(arg, "--jobs=") {
+			n, err := strconv.Atoi(arg[len("--jobs="):])
+			if err != nil || n < 1 {
+				return fmt.Errorf("error: invalid --jobs value '%s'", arg[len("--jobs="):])
+			}
+			app.urlArgs.jobs = n
+		} else if strings.HasPrefix(arg, "--max-per-host=") {
+			n, err := strconv.Atoi(arg[len("--max-per-host="):])
+			if err != nil || n < 1 {
+				return fmt.Errorf("error: invalid --max-per-host value '%s'", arg[len("--max-per-host="):])
+			}
+			app.urlArgs.maxPerHost = n
+		} else if strings.HasPrefix(arg, "--level=") {
+			value := arg[len("--level="):]
+			if value == "inf" {
+				value = "0"
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("error: invalid --level value '%s'", arg[len("--level="):])
+			}
+			app.urlArgs.level = n
+		} else if strings.HasPrefix(arg, "--quota=") {
+			quota, err := utils.ParseSize(arg[len("--quota="):])
+			if err != nil {
+				return fmt.Errorf("error: --quota: %v", err)
+			}
+			app.urlArgs.quota = quota
+		} else if strings.HasPrefix(arg, "--max-pages=") {
+			n, err := strconv.Atoi(arg[len("--max-pages="):])
+			if err != nil || n < 1 {
+				return fmt.Errorf("error: invalid --max-pages value '%s'", arg[len("--max-pages="):])
+			}
+			app.urlArgs.maxPages = n
+		} else if strings.HasPrefix(arg, "--wait=") {
+			wait, err := parseSeconds("--wait", arg[len("--wait="):])
+			if err != nil {
+				return err
+			}
+			app.urlArgs.wait = wait
+		} else if arg == "--span-hosts" || arg == "-H" {
+			app.urlArgs.spanHosts = true
+		} else if strings.HasPrefix(arg, "--domains=") {
+			app.urlArgs.domains = arg[len("--domains="):]
+		} else if strings.HasPrefix(arg, "--exclude-domains=") {
+			app.urlArgs.excludeDomains = arg[len("--exclude-domains="):]
+		} else if arg == "--page-requisites" || arg == "-p" {
+			app.urlArgs.pageRequisites = true
+		} else if arg == "--no-parent" || arg == "-np" {
+			app.urlArgs.noParent = true
+		} else if strings.HasPrefix(arg, "--robots=") {
+			switch strings.ToLower(arg[len("--robots="):]) {
+			case "on":
+				app.urlArgs.robotsOff = false
+			case "off":
+				app.urlArgs.robotsOff = true
+			default:
+				return fmt.Errorf("error: --robots must be on or off")
+			}
+		} else if strings.HasPrefix(arg, "--failed-list=") {
+			app.urlArgs.failedList = arg[len("--failed-list="):]
+		} else if arg == "-c" || arg == "--continue" {
+			app.urlArgs.continueDownload = true
 		} else if strings.HasPrefix(arg, "-B") {
 			app.urlArgs.workInBackground = true
 		} else if strings.HasPrefix(arg, "-i=") {
@@ -115,10 +328,43 @@ func (app *AppState) parseArgs() error {
 		}
 	}
 
-	if app.urlArgs.rateLimit != "" {
-		if strings.ToLower(string(app.urlArgs.rateLimit[len(app.urlArgs.rateLimit)-1])) != "k" &&
-			strings.ToLower(string(app.urlArgs.rateLimit[len(app.urlArgs.rateLimit)-1])) != "m" {
-			return fmt.Errorf("invalid rateLimit")
+	if app.urlArgs.rateLimit != "" && !validRateLimit(app.urlArgs.rateLimit) {
+		return fmt.Errorf("invalid rateLimit")
+	}
+
+	if err := app.buildRetryPolicy(); err != nil {
+		return err
+	}
+	if err := app.buildHttpClient(); err != nil {
+		return err
+	}
+	if err := app.loadRequestBody(); err != nil {
+		return err
+	}
+	if err := app.loadCredentials(); err != nil {
+		return err
+	}
+	if app.urlArgs.loadCookies != "" {
+		if err := app.cookies.Load(app.urlArgs.loadCookies); err != nil {
+			return err
+		}
+	}
+
+	if app.urlArgs.checksum != nil && (app.urlArgs.sourceFile != "" || app.urlArgs.mirroring) {
+		return fmt.Errorf("error: --checksum applies to a single download; give per-line checksums in the -i file instead")
+	}
+
+	if app.urlArgs.sourceFile != "" && app.urlArgs.file != "" {
+		return fmt.Errorf("error: -O cannot be used with -i; set out= on each entry of the input file instead")
+	}
+
+	if app.urlArgs.failedList != "" && app.urlArgs.sourceFile == "" {
+		return fmt.Errorf("error: --failed-list can only be used with -i")
+	}
+
+	if app.urlArgs.segments > 1 {
+		if app.urlArgs.sourceFile != "" || app.urlArgs.continueDownload {
+			return fmt.Errorf("error: --segments cannot be used with -i or --continue")
 		}
 	}
 
@@ -130,13 +376,27 @@ func (app *AppState) parseArgs() error {
 
 	// Check for invalid flag combinations if --mirror is provided
 	if app.urlArgs.mirroring {
-		if app.urlArgs.file != "" || app.urlArgs.path != "" || app.urlArgs.rateLimit != "" || app.urlArgs.sourceFile != "" || app.urlArgs.workInBackground {
+		if app.urlArgs.file != "" || app.urlArgs.path != "" || app.urlArgs.rateLimit != "" || app.urlArgs.sourceFile != "" || app.urlArgs.workInBackground || app.urlArgs.segments > 1 {
 			return fmt.Errorf("error: --mirror can only be used with --convert-links, --reject, --exclude, and a url. No other flags are allowed")
 		}
 	} else {
 		if app.urlArgs.convertLinksFlag || app.urlArgs.rejectFlag != "" || app.urlArgs.excludeFlag != "" {
 			return fmt.Errorf("error: --convert-links, --reject, and --exclude can only be used with --mirror")
 		}
+		if app.urlArgs.level > 0 || app.urlArgs.quota > 0 || app.urlArgs.maxPages > 0 || app.urlArgs.noParent || app.urlArgs.robotsOff || app.urlArgs.wait > 0 {
+			return fmt.Errorf("error: --level, --quota, --max-pages, --no-parent, --wait and --robots can only be used with --mirror")
+		}
+		if app.urlArgs.spanHosts || app.urlArgs.domains != "" || app.urlArgs.excludeDomains != "" || app.urlArgs.pageRequisites {
+			return fmt.Errorf("error: --span-hosts, --domains, --exclude-domains and --page-requisites can only be used with --mirror")
+		}
+	}
+
+	if app.urlArgs.noClobber && (app.urlArgs.timestamping || app.urlArgs.backups > 0) {
+		return fmt.Errorf("error: --no-clobber cannot be used with --timestamping or --backups")
+	}
+
+	if app.urlArgs.backupConverted && !app.urlArgs.convertLinksFlag {
+		return fmt.Errorf("error: --backup-converted can only be used with --convert-links")
 	}
 
 	// Ensure url is provided
@@ -144,11 +404,151 @@ func (app *AppState) parseArgs() error {
 		return fmt.Errorf("error: url not provided")
 	}
 
-	// Validate the url
-	err := utils.Validateurl(app.urlArgs.url)
+	// Validate the url, which an -i list stands in for
+	if app.urlArgs.url != "" {
+		if err := utils.Validateurl(app.urlArgs.url); err != nil {
+			return fmt.Errorf("error: invalid url provided")
+		}
+	}
+
+	return nil
+}
+
+// buildRetryPolicy applies --tries, --waitretry and --retry-on-http-error on
+// top of the default retry policy.
+func (app *AppState) buildRetryPolicy() error {
+	if app.urlArgs.tries != "" {
+		tries, err := strconv.Atoi(app.urlArgs.tries)
+		if err != nil || tries < 1 {
+			return fmt.Errorf("error: invalid --tries value '%s'", app.urlArgs.tries)
+		}
+		app.retry.Tries = tries
+	}
+	if app.urlArgs.waitRetry != "" {
+		wait, err := parseSeconds("--waitretry", app.urlArgs.waitRetry)
+		if err != nil {
+			return err
+		}
+		app.retry.WaitRetry = wait
+	}
+	if app.urlArgs.retryOnHTTPError != "" {
+		codes, err := utils.ParseStatusList(app.urlArgs.retryOnHTTPError)
+		if err != nil {
+			return fmt.Errorf("error: --retry-on-http-error: %v", err)
+		}
+		app.retry.RetryOn = codes
+	}
+	return nil
+}
+
+// buildHttpClient creates the client shared by every request. --timeout sets
+// both the connect and read timeouts, which the specific flags then override.
+func (app *AppState) buildHttpClient() error {
+	opts := utils.DefaultClientOptions()
+	opts.Proxy = app.urlArgs.proxy
+	opts.Jar = app.cookies
+
+	if app.urlArgs.timeout != "" {
+		timeout, err := parseSeconds("--timeout", app.urlArgs.timeout)
+		if err != nil {
+			return err
+		}
+		opts.ConnectTimeout, opts.ReadTimeout = timeout, timeout
+	}
+	if app.urlArgs.connectTimeout != "" {
+		timeout, err := parseSeconds("--connect-timeout", app.urlArgs.connectTimeout)
+		if err != nil {
+			return err
+		}
+		opts.ConnectTimeout = timeout
+	}
+	if app.urlArgs.readTimeout != "" {
+		timeout, err := parseSeconds("--read-timeout", app.urlArgs.readTimeout)
+		if err != nil {
+			return err
+		}
+		opts.ReadTimeout = timeout
+	}
+	if app.urlArgs.maxIdlePerHost != "" {
+		n, err := strconv.Atoi(app.urlArgs.maxIdlePerHost)
+		if err != nil || n < 0 {
+			return fmt.Errorf("error: invalid --max-idle-per-host value '%s'", app.urlArgs.maxIdlePerHost)
+		}
+		opts.MaxIdleConnsPerHost = n
+	}
+
+	client, err := utils.NewHttpClient(opts)
 	if err != nil {
-		return fmt.Errorf("error: invalid url provided")
+		return fmt.Errorf("error: %v", err)
 	}
+	app.client = client
+	return nil
+}
+
+// parseSeconds parses a flag value given in (possibly fractional) seconds.
+func parseSeconds(flag, value string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("error: invalid %s value '%s'", flag, value)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
 
+// loadRequestBody resolves --body-data or --body-file into the body sent with
+// every request.
+func (app *AppState) loadRequestBody() error {
+	switch {
+	case app.urlArgs.bodyData != "" && app.urlArgs.bodyFile != "":
+		return fmt.Errorf("error: --body-data and --body-file cannot be used together")
+	case app.urlArgs.bodyData != "":
+		app.urlArgs.request.Body = []byte(app.urlArgs.bodyData)
+	case app.urlArgs.bodyFile != "":
+		body, err := os.ReadFile(app.urlArgs.bodyFile)
+		if err != nil {
+			return fmt.Errorf("error reading body file:\n%v", err)
+		}
+		app.urlArgs.request.Body = body
+	}
 	return nil
 }
+
+// loadCredentials completes the credentials given on the command line. The
+// password and bearer token may also come from WGET_PASSWORD and
+// WGET_BEARER_TOKEN, which keeps them out of the process list and is how
+// they reach a background download.
+func (app *AppState) loadCredentials() error {
+	creds := &app.urlArgs.credentials
+	if creds.Password == "" {
+		creds.Password = os.Getenv("WGET_PASSWORD")
+	}
+	if creds.BearerToken == "" {
+		creds.BearerToken = os.Getenv("WGET_BEARER_TOKEN")
+	}
+
+	if app.urlArgs.askPassword {
+		if app.urlArgs.credentials.Password != "" {
+			return fmt.Errorf("error: --ask-password cannot be used with --password")
+		}
+		password, err := utils.ReadPassword(fmt.Sprintf("Password for user '%s': ", creds.User))
+		if err != nil {
+			return err
+		}
+		creds.Password = password
+	}
+	return nil
+}
+
+// validRateLimit reports whether limit is a number with a k or M unit, as
+// --rate-limit expects, that lets at least one byte through per second. A
+// limit of zero would stall the download for good.
+func validRateLimit(limit string) bool {
+	if limit == "" {
+		return false
+	}
+	unit := strings.ToLower(limit[len(limit)-1:])
+	if unit != "k" && unit != "m" {
+		return false
+	}
+	rate, err := utils.ParseRateLimit(limit)
+	return err == nil && rate > 0
+}
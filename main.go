@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"wget/appState"
+	"wget/utils"
 )
 
 func main() {
@@ -12,9 +16,15 @@ func main() {
 		return
 	}
 
-	_, err := appState.GetAppState()
+	// Ctrl-C or SIGTERM cancels the context: no new downloads start, requests
+	// in flight are aborted and partial files are kept for --continue
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	_, err := appState.GetAppState(ctx)
 	if err != nil {
 		fmt.Println(err)
-		return
+		stop()
+		os.Exit(utils.ExitCode(err))
 	}
 }
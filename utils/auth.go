@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials are sent with requests to the hosts the user asked for. A bearer
+// token takes precedence over a user name and password.
+type Credentials struct {
+	User        string
+	Password    string
+	BearerToken string
+}
+
+// Empty reports whether there is nothing to authenticate with.
+func (c Credentials) Empty() bool {
+	return c.User == "" && c.Password == "" && c.BearerToken == ""
+}
+
+// LookupNetrc returns the login and password for host from the netrc file at
+// path, falling back to its "default" entry. A missing file is not an error.
+func LookupNetrc(path, host string) (Credentials, bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Credentials{}, false, nil
+	}
+	if err != nil {
+		return Credentials{}, false, fmt.Errorf("error reading netrc file:\n%v", err)
+	}
+
+	var (
+		found, fallback       Credentials
+		matched, haveFallback bool
+		current               *Credentials
+	)
+	fields := strings.Fields(string(data))
+	for i := 0; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch fields[i] {
+		case "machine":
+			current = nil
+			if next() == host && !matched {
+				matched = true
+				current = &found
+			}
+		case "default":
+			current = nil
+			if !haveFallback {
+				haveFallback = true
+				current = &fallback
+			}
+		case "login":
+			if value := next(); current != nil {
+				current.User = value
+			}
+		case "password":
+			if value := next(); current != nil {
+				current.Password = value
+			}
+		case "account":
+			next()
+		case "macdef":
+			// Macro definitions run until a blank line, which Fields has
+			// already discarded, so skip the macro name and stop matching
+			next()
+			current = nil
+		}
+	}
+
+	if matched {
+		return found, true, nil
+	}
+	return fallback, haveFallback, nil
+}
+
+// NetrcPath returns $NETRC when set and ~/.netrc otherwise.
+func NetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// ReadPassword prompts on stderr and reads a line from stdin, turning off
+// terminal echo while the user types.
+func ReadPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		if stty("-echo") == nil {
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("error reading password:\n%v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// RedactURL hides any password embedded in rawURL so it can be printed or
+// written to wget-log.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
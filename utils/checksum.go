@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when downloaded content does not hash to
+// the expected value.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Checksum is an expected digest given as "algorithm:hex", for example
+// "sha256:9f86d0...".
+type Checksum struct {
+	Algorithm string
+	Expected  []byte
+}
+
+// ParseChecksum parses a --checksum value or the checksum column of an -i file.
+func ParseChecksum(spec string) (*Checksum, error) {
+	algorithm, digest, found := strings.Cut(spec, ":")
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	newHash, known := checksumAlgorithms[algorithm]
+	if !found || !known {
+		return nil, fmt.Errorf("invalid checksum '%s', expected md5, sha1, sha256 or sha512 followed by ':' and the hex digest", spec)
+	}
+	expected, err := hex.DecodeString(strings.TrimSpace(digest))
+	if err != nil || len(expected) != newHash().Size() {
+		return nil, fmt.Errorf("invalid %s digest '%s'", algorithm, digest)
+	}
+	return &Checksum{Algorithm: algorithm, Expected: expected}, nil
+}
+
+// NewHash returns a fresh hash for the checksum's algorithm.
+func (c *Checksum) NewHash() hash.Hash {
+	return checksumAlgorithms[c.Algorithm]()
+}
+
+// Verify compares the digest computed by h with the expected one.
+func (c *Checksum) Verify(h hash.Hash) error {
+	if sum := h.Sum(nil); !bytes.Equal(sum, c.Expected) {
+		return fmt.Errorf("%w: expected %s:%x, got %s:%x", ErrChecksumMismatch, c.Algorithm, c.Expected, c.Algorithm, sum)
+	}
+	return nil
+}
+
+func (c *Checksum) String() string {
+	return fmt.Sprintf("%s:%x", c.Algorithm, c.Expected)
+}
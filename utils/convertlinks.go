@@ -1,114 +1,143 @@
 package utils
 
 import (
-	"fmt"
+	"bytes"
 	"net/url"
 	"os"
-	"path"
-	"regexp"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/net/html"
 )
 
-func ConvertLinks(htmlFilePath string) {
-	htmlFilePath = removeHTTP(htmlFilePath)
+// LinkConverter rewrites the links in mirrored pages and stylesheets for
+// offline viewing, as wget's --convert-links does. A link to a file the
+// mirror saved becomes a path relative to the file holding the link; any
+// other link becomes the full remote URL, so it keeps working from the
+// local copy instead of pointing at a file that does not exist.
+type LinkConverter struct {
+	Backup      bool     // keep what the server sent in a .orig file
+	StripParams []string // as passed to CanonicalURL while mirroring
 
-	if !strings.HasSuffix(htmlFilePath, ".html") {
-		return
-	}
+	local map[string]string // localKey of a URL to the path it was saved at
+}
 
-	// Read the HTML file content
-	htmlData, err := os.ReadFile(htmlFilePath)
-	if err != nil {
-		fmt.Println("Error reading HTML file:", err)
-		return
+// Add records that rawURL was saved at path.
+func (c *LinkConverter) Add(rawURL, path string) {
+	if c.local == nil {
+		c.local = make(map[string]string)
 	}
+	c.local[localKey(CanonicalURL(rawURL, c.StripParams))] = path
+}
 
-	// Parse the HTML content
-	doc, err := html.Parse(strings.NewReader(string(htmlData)))
+// localKey identifies a URL by host, path and query. Mirrors lay files out
+// by host name alone, so the scheme and port do not tell files apart.
+func localKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		fmt.Println("Error parsing HTML:", err)
-		return
+		return rawURL
 	}
-
-	// Modify the document by converting external links to local paths
-	modifyLinks(doc, path.Dir(htmlFilePath))
-
-	// Convert the modified HTML back to string
-	var modifiedHTML strings.Builder
-	err = html.Render(&modifiedHTML, doc)
-	if err != nil {
-		fmt.Println("Error rendering modified HTML:", err)
-		return
+	key := u.Hostname() + u.EscapedPath()
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
 	}
-
-	// Save the modified HTML back to the file
-	err = os.WriteFile(htmlFilePath, []byte(modifiedHTML.String()), 0o644)
-	if err != nil {
-		fmt.Println("Error writing modified HTML file:", err)
-		return
-	}
-
-	fmt.Printf("\nAll %s links converted for offline viewing.\n", htmlFilePath)
+	return key
 }
 
-func modifyLinks(n *html.Node, basePath string) {
-	if n.Type == html.ElementNode {
-		for i, attr := range n.Attr {
-			if attr.Key == "href" || attr.Key == "src" {
-				n.Attr[i].Val = getLocalPath(attr.Val)
-			} else if attr.Key == "style" {
-				n.Attr[i].Val = convertCSSURLs(attr.Val)
-			}
+// ConvertHTML rewrites the links of the page saved at path, fetched from
+// pageURL. The page's <base href> is removed since the converted links no
+// longer depend on it.
+func (c *LinkConverter) ConvertHTML(path, pageURL string) error {
+	return c.rewriteFile(path, func(data []byte) ([]byte, error) {
+		doc, err := html.Parse(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
 		}
-
-		if n.Data == "style" && n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
-			n.FirstChild.Data = convertCSSURLs(n.FirstChild.Data)
+		base := pageURL
+		if href := findBase(doc); href != "" {
+			base = ResolveURL(pageURL, href)
 		}
-	}
-
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		modifyLinks(c, basePath)
-	}
+		removeBase(doc)
+		RewriteHTMLLinks(doc, func(link string, page bool) string {
+			return c.convert(path, base, link)
+		})
+
+		var out bytes.Buffer
+		if err := html.Render(&out, doc); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	})
 }
 
-func convertCSSURLs(cssContent string) string {
-	re := regexp.MustCompile(`url\(([^)]+)\)`)
-	return re.ReplaceAllStringFunc(cssContent, func(match string) string {
-		url := strings.Trim(match[4:len(match)-1], "'\"")
-		localPath := getLocalPath(url)
-		return fmt.Sprintf("url('%s')", localPath)
+// ConvertCSS rewrites the links of the stylesheet saved at path, fetched
+// from sheetURL.
+func (c *LinkConverter) ConvertCSS(path, sheetURL string) error {
+	return c.rewriteFile(path, func(data []byte) ([]byte, error) {
+		css := RewriteCSSLinks(string(data), func(link string) string {
+			return c.convert(path, sheetURL, link)
+		})
+		return []byte(css), nil
 	})
 }
 
-func getLocalPath(originalURL string) string {
-	if strings.HasPrefix(originalURL, "http") || strings.HasPrefix(originalURL, "//") {
-		parsedURL, err := url.Parse(originalURL)
-		if err != nil {
-			return originalURL
+// rewriteFile replaces the file at path with convert applied to what the
+// server sent. That is read from path.orig when an earlier conversion kept
+// a backup, so converting again never works on converted links; otherwise
+// it is the file itself, which is first copied to path.orig with Backup.
+func (c *LinkConverter) rewriteFile(path string, convert func([]byte) ([]byte, error)) error {
+	backup := path + ".orig"
+	data, err := os.ReadFile(backup)
+	backedUp := err == nil
+	if !backedUp {
+		if data, err = os.ReadFile(path); err != nil {
+			return err
 		}
-		return path.Join(parsedURL.Host, parsedURL.Path)
-	} else if strings.HasPrefix(originalURL, "/") {
-		return path.Join(".", originalURL)
 	}
-	return originalURL
+	converted, err := convert(data)
+	if err != nil {
+		return err
+	}
+	if c.Backup && !backedUp {
+		if err := os.WriteFile(backup, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, converted, 0o644)
 }
 
-// removeHTTP removes the http:// or https:// prefix from the URL.
-func removeHTTP(url string) string {
-	re := regexp.MustCompile(`^https?://`)
-	modifiedURL := re.ReplaceAllString(url, "")
-	isBaseURL := regexp.MustCompile(`^[^/]+/?$`).MatchString(modifiedURL)
-
-	// If the URL is a base URL, append "index.html" if it's not already present
-	if isBaseURL {
-		if strings.HasSuffix(modifiedURL, "/") {
-			modifiedURL += "index.html"
-		} else {
-			modifiedURL += "/index.html"
+// convert returns what a link on the file at path, resolved against base,
+// should be replaced with. The link's fragment is kept.
+func (c *LinkConverter) convert(path, base, link string) string {
+	resolved := ResolveURL(base, link)
+	if resolved == "" {
+		return link
+	}
+	fragment := ""
+	if i := strings.Index(link, "#"); i >= 0 {
+		fragment = link[i:]
+	}
+	if local, ok := c.local[localKey(CanonicalURL(resolved, c.StripParams))]; ok {
+		if rel, err := filepath.Rel(filepath.Dir(path), local); err == nil {
+			// Escaping keeps names with "?", "#" or spaces readable as paths
+			return (&url.URL{Path: filepath.ToSlash(rel)}).String() + fragment
 		}
 	}
+	return resolved + fragment
+}
 
-	return modifiedURL
+// removeBase drops the href of every <base> element in n.
+func removeBase(n *html.Node) {
+	if n.Type == html.ElementNode && n.Data == "base" {
+		attrs := n.Attr[:0]
+		for _, a := range n.Attr {
+			if a.Key != "href" {
+				attrs = append(attrs, a)
+			}
+		}
+		n.Attr = attrs
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		removeBase(c)
+	}
 }
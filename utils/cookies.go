@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieJar is an http.CookieJar shared by every request of a run. It keeps
+// a copy of each cookie it accepts so they can be written back out in
+// Netscape cookies.txt format, which the standard library jar cannot list.
+type CookieJar struct {
+	jar     *cookiejar.Jar
+	mu      sync.Mutex
+	entries map[string]cookieEntry
+}
+
+// cookieEntry is one line of a cookies.txt file.
+type cookieEntry struct {
+	domain   string // without the leading dot
+	hostOnly bool   // false when subdomains also receive the cookie
+	path     string
+	secure   bool
+	httpOnly bool
+	expires  time.Time // zero for session cookies
+	name     string
+	value    string
+}
+
+func NewCookieJar() (*CookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, fmt.Errorf("error creating cookie jar: %v", err)
+	}
+	return &CookieJar{jar: jar, entries: make(map[string]cookieEntry)}, nil
+}
+
+// SetCookies implements http.CookieJar.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		entry := cookieEntry{
+			domain:   strings.TrimPrefix(strings.ToLower(c.Domain), "."),
+			path:     c.Path,
+			secure:   c.Secure,
+			httpOnly: c.HttpOnly,
+			name:     c.Name,
+			value:    c.Value,
+		}
+		host := strings.ToLower(u.Hostname())
+		if entry.domain == "" {
+			entry.domain = host
+			entry.hostOnly = true
+		} else if !domainMatches(host, entry.domain) {
+			// The underlying jar rejects these too
+			continue
+		}
+		if entry.path == "" || entry.path[0] != '/' {
+			entry.path = defaultCookiePath(u.Path)
+		}
+		switch {
+		case c.MaxAge > 0:
+			entry.expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		case c.MaxAge < 0:
+			entry.expires = time.Unix(1, 0)
+		default:
+			entry.expires = c.Expires
+		}
+
+		key := entry.domain + ";" + entry.path + ";" + entry.name
+		if !entry.expires.IsZero() && entry.expires.Before(time.Now()) {
+			delete(j.entries, key)
+			continue
+		}
+		j.entries[key] = entry
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// Load reads cookies from a Netscape format cookies.txt file.
+func (j *CookieJar) Load(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening cookie file:\n%v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		httpOnly := false
+		if rest, found := strings.CutPrefix(line, "#HttpOnly_"); found {
+			line, httpOnly = rest, true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("error in cookie file %s line %d: expected 7 tab separated fields", filePath, lineNo)
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("error in cookie file %s line %d: invalid expiry '%s'", filePath, lineNo, fields[4])
+		}
+
+		domain := strings.TrimPrefix(fields[0], ".")
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = domain
+		}
+		if expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		j.SetCookies(&url.URL{Scheme: scheme, Host: domain, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading cookie file:\n%v", err)
+	}
+	return nil
+}
+
+// Save writes the cookies in Netscape format. Session cookies are only kept
+// when keepSession is set, matching --keep-session-cookies.
+func (j *CookieJar) Save(filePath string, keepSession bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n# Generated by wget. Edit at your own risk.\n\n")
+	keys := make([]string, 0, len(j.entries))
+	for key := range j.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	for _, key := range keys {
+		e := j.entries[key]
+		if e.expires.IsZero() && !keepSession {
+			continue
+		}
+		if !e.expires.IsZero() && e.expires.Before(now) {
+			continue
+		}
+
+		domain := e.domain
+		if !e.hostOnly {
+			domain = "." + domain
+		}
+		if e.httpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expiry int64
+		if !e.expires.IsZero() {
+			expiry = e.expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(!e.hostOnly), e.path, netscapeBool(e.secure), expiry, e.name, e.value)
+	}
+
+	if err := os.WriteFile(filePath, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("error saving cookies:\n%v", err)
+	}
+	return nil
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// domainMatches reports whether a cookie for domain may be set by host: the
+// host must be the domain or one of its subdomains, and the domain must not
+// be a public suffix such as "co.uk".
+func domainMatches(host, domain string) bool {
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return false
+	}
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix != domain || host == domain
+}
+
+// defaultCookiePath implements the default-path algorithm of RFC 6265 5.1.4.
+func defaultCookiePath(urlPath string) string {
+	if urlPath == "" || urlPath[0] != '/' {
+		return "/"
+	}
+	return path.Dir(urlPath)
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Exit codes follow wget's, plus one for checksum failures.
+const (
+	ExitSuccess  = 0
+	ExitGeneric  = 1
+	ExitParse    = 2
+	ExitFileIO   = 3
+	ExitNetwork  = 4
+	ExitSSL      = 5
+	ExitAuth     = 6
+	ExitProtocol = 7
+	ExitServer   = 8
+	ExitChecksum = 9
+	// ExitInterrupted matches what a shell reports for a process killed by
+	// SIGINT.
+	ExitInterrupted = 130
+)
+
+// StatusError is returned when the server answers with an unexpected HTTP
+// status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	URL        string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("error: status %s\nurl: [%s]", e.Status, RedactURL(e.URL))
+}
+
+// ExitError attaches an explicit exit code to an error, for failures that
+// cannot be classified from the error alone such as bad arguments or a batch
+// in which several downloads failed.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string { return e.Err.Error() }
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// ExitCode maps an error to the wget exit code that describes it best.
+// Local file errors are checked before network errors since both may wrap
+// the same syscall errors.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	if errors.Is(err, ErrChecksumMismatch) {
+		return ExitChecksum
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case 401, 403, 407:
+			return ExitAuth
+		}
+		return ExitServer
+	}
+
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) {
+		return ExitFileIO
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &recordErr) {
+		return ExitSSL
+	}
+
+	var netErr net.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &netErr) || errors.As(err, &opErr) || errors.As(err, &dnsErr) || IsRetryableError(err) {
+		return ExitNetwork
+	}
+
+	return ExitGeneric
+}
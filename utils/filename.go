@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFileNameLength is the longest name, in bytes, most file systems accept.
+const maxFileNameLength = 255
+
+// reservedNames are the device names Windows refuses as file names, with or
+// without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// URLFileName returns the name a download of rawURL is saved under when no
+// -O is given: the last path segment with any query kept as LocalFileName
+// does, made safe by SanitizeFileName, or index.html for a directory URL.
+func URLFileName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "index.html"
+	}
+	if name := SanitizeFileName(LocalFileName(u)); name != "" {
+		return name
+	}
+	return "index.html"
+}
+
+// ContentDispositionFileName returns the file name a Content-Disposition
+// header suggests, preferring the RFC 5987 filename* form, made safe by
+// SanitizeFileName. It returns "" when the header suggests none.
+func ContentDispositionFileName(header string) string {
+	if header == "" {
+		return ""
+	}
+	// mime decodes filename* into filename, replacing any plain filename
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return SanitizeFileName(params["filename"])
+}
+
+// SanitizeFileName makes a name taken from a URL or a server safe to create
+// in the current directory. Any directory part is dropped, so "../x" and
+// "C:\x" cannot escape it; control characters and characters Windows
+// forbids become "_"; leading dots, so the name is neither hidden nor "..",
+// and trailing dots and spaces are removed; Windows device names get a "_"
+// prefix; and overlong names are shortened, keeping the extension. It
+// returns "" when nothing usable is left.
+func SanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, ". ")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return ""
+	}
+
+	stem := strings.ToUpper(name)
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if reservedNames[stem] {
+		name = "_" + name
+	}
+
+	if len(name) > maxFileNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxFileNameLength/2 {
+			ext = ""
+		}
+		name = truncateUTF8(name[:len(name)-len(ext)], maxFileNameLength-len(ext)) + ext
+	}
+	return name
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"net/url"
@@ -11,47 +14,6 @@ import (
 	"strings"
 )
 
-func IsRejected(url, rejectTypes string) bool {
-	if rejectTypes == "" {
-		return false
-	}
-
-	rejectedTypes := strings.Split(rejectTypes, ",")
-	for _, ext := range rejectedTypes {
-		if strings.HasSuffix(url, ext) {
-			return true
-		}
-	}
-	return false
-}
-
-func IsRejectedPath(url, pathRejects string) bool {
-	if pathRejects == "" {
-		return false
-	}
-
-	rejects := strings.Split(pathRejects, ",")
-	for _, path := range rejects {
-		if path[0] != '/' {
-			continue
-		}
-		if contains(url, path) {
-			return true
-		}
-	}
-
-	return false
-}
-
-func contains(str, substr string) bool {
-	for i := 0; i < len(str)-len(substr); i++ {
-		if str[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
 func FileExists(path string) bool {
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -68,39 +30,81 @@ func ExtractDomain(urlStr string) (string, error) {
 	return u.Hostname(), nil
 }
 
-// isValidAttribute checks if an HTML tag attribute is valid for processing
-func IsValidAttribute(tagName, attrKey string) bool {
-	return (tagName == "a" && attrKey == "href") ||
-		(tagName == "img" && attrKey == "src") ||
-		(tagName == "script" && attrKey == "src") ||
-		(tagName == "link" && attrKey == "href")
+// HttpRangeRequest requests url starting at byte offset. An offset of zero
+// sends a plain request; anything larger asks for "Range: bytes=offset-".
+func HttpRangeRequest(ctx context.Context, client *http.Client, opts RequestOptions, url string, offset int64) (*http.Response, error) {
+	byteRange := ""
+	if offset > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-", offset)
+	}
+	return sendRequest(ctx, client, opts, opts.method(), url, byteRange)
+}
+
+// HttpSegmentRequest requests the inclusive byte range start-end of url.
+func HttpSegmentRequest(ctx context.Context, client *http.Client, opts RequestOptions, url string, start, end int64) (*http.Response, error) {
+	return sendRequest(ctx, client, opts, opts.method(), url, fmt.Sprintf("bytes=%d-%d", start, end))
 }
 
-func HttpRequest(url string) (*http.Response, error) {
-	// Create a new HTTP client
-	client := &http.Client{}
+// HttpHeadRequest sends a HEAD request, used to probe a resource's size and
+// range support before downloading it.
+func HttpHeadRequest(ctx context.Context, client *http.Client, opts RequestOptions, url string) (*http.Response, error) {
+	return sendRequest(ctx, client, opts, "HEAD", url, "")
+}
 
-	// Create a new request with a User-Agent header
-	req, err := http.NewRequest("GET", url, nil)
+func sendRequest(ctx context.Context, client *http.Client, opts RequestOptions, method, url, byteRange string) (*http.Response, error) {
+	var body io.Reader
+	if method != "HEAD" && len(opts.Body) > 0 {
+		body = bytes.NewReader(opts.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
-
-	// Set headers to mimic a Chrome browser
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.85 Safari/537.36")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("Connection", "keep-alive")
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	opts.apply(req)
 
 	// Send the request
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %v", err)
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 
 	return resp, err
 }
 
+// ParseContentRange parses a "bytes start-end/total" Content-Range header.
+// total is -1 when the server reports it as "*".
+func ParseContentRange(header string) (start, end, total int64, err error) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !found {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+	rng, size, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+		}
+	}
+	first, last, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+	return start, end, total, nil
+}
+
 // ExpandPath expands shorthand notations to full paths
 func ExpandPath(path string) (string, error) {
 	// 1. Expand `~` to the home directory
@@ -140,6 +144,34 @@ func FormatSpeed(speed float64) string {
 	return fmt.Sprintf("%.0fKiB", speed)
 }
 
+// ParseSize parses a byte count such as "500", "300k", "20m" or "2g", using
+// binary multiples. "inf" and 0 mean no limit and parse as 0.
+func ParseSize(size string) (int64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	if size == "inf" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	if size != "" {
+		switch size[len(size)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			size = size[:len(size)-1]
+		}
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return n * multiplier, nil
+}
+
 // SaveShowProgressState saves the showProgress state to a temporary file.
 func SaveShowProgressState(tempConfigFile string, showProgress bool) error {
 	data := []byte(strconv.FormatBool(showProgress))
@@ -177,40 +209,6 @@ func LoadShowProgressState(tempConfigFile string) (bool, error) {
 	return showProgress, nil
 }
 
-func ResolveURL(base, rel string) string {
-	// Remove fragment identifiers (anything starting with #)
-	if fragmentIndex := strings.Index(rel, "#"); fragmentIndex != -1 {
-		rel = rel[:fragmentIndex]
-	}
-
-	if strings.HasPrefix(rel, "http") {
-		return rel
-	}
-
-	if strings.HasPrefix(rel, "//") {
-		protocol := "http:"
-		if strings.HasPrefix(base, "https") {
-			protocol = "https:"
-		}
-		return protocol + rel
-	}
-
-	if strings.HasPrefix(rel, "/") {
-		return strings.Join(strings.Split(base, "/")[:3], "/") + rel
-	}
-	if strings.HasPrefix(rel, "./") {
-		return strings.Join(strings.Split(base, "/")[:3], "/") + rel[1:]
-	}
-	if strings.HasPrefix(rel, "//") && strings.Contains(rel[2:], "/") {
-		baseParts := strings.Split(base, "/")
-		return baseParts[0] + "//" + baseParts[2] + rel[1:]
-	}
-
-	baseParts := strings.Split(base, "/")
-	return baseParts[0] + "//" + baseParts[2] + "/" + rel
-}
-
-
 func Validateurl(link string) error {
 	_, err := url.ParseRequestURI(link)
 	if err != nil {
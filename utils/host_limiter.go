@@ -0,0 +1,48 @@
+package utils
+
+import "sync"
+
+// HostLimiter caps how many requests may be in flight to the same host at
+// once. A limit of 0 or less disables it.
+type HostLimiter struct {
+	limit int
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func NewHostLimiter(limit int) *HostLimiter {
+	return &HostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// TryAcquire takes a slot for host when one is free and reports whether it
+// did. It never blocks, so callers can go on to work for other hosts.
+func (l *HostLimiter) TryAcquire(host string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[host] = slot
+	}
+	l.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees a slot taken by TryAcquire.
+func (l *HostLimiter) Release(host string) {
+	if l.limit <= 0 {
+		return
+	}
+	l.mu.Lock()
+	slot := l.slots[host]
+	l.mu.Unlock()
+	<-slot
+}
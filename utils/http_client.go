@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ClientOptions configures the HTTP client shared by every download.
+type ClientOptions struct {
+	ConnectTimeout      time.Duration // time allowed to establish a TCP connection
+	ReadTimeout         time.Duration // longest idle gap between two reads
+	MaxIdleConnsPerHost int
+	Proxy               string // explicit proxy URL, overrides the environment
+	Jar                 http.CookieJar
+}
+
+// DefaultClientOptions mirrors wget's defaults: no total deadline, a generous
+// read timeout, and keep-alive connections reused across requests.
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		ConnectTimeout:      30 * time.Second,
+		ReadTimeout:         900 * time.Second,
+		MaxIdleConnsPerHost: 16,
+	}
+}
+
+// NewHttpClient builds a client with a pooled transport. Proxies come from
+// opts.Proxy when set and from HTTP_PROXY, HTTPS_PROXY and NO_PROXY otherwise.
+func NewHttpClient(opts ClientOptions) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url: %s", opts.Proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil || opts.ReadTimeout <= 0 {
+				return conn, err
+			}
+			return &deadlineConn{Conn: conn, readTimeout: opts.ReadTimeout}, nil
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		ResponseHeaderTimeout: opts.ReadTimeout,
+	}
+	return &http.Client{Transport: transport, Jar: opts.Jar}, nil
+}
+
+// deadlineConn pushes the read deadline forward before every read, so a
+// stalled transfer fails after readTimeout of silence rather than hanging.
+type deadlineConn struct {
+	net.Conn
+	readTimeout time.Duration
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
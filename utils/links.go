@@ -0,0 +1,288 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Link is a reference found in an HTML or CSS document, as written there.
+type Link struct {
+	URL  string
+	Page bool // a document to crawl in turn rather than an asset the page needs
+}
+
+// requisiteRels are the <link rel> values naming something a page needs to
+// render; links with any other rel point at further documents.
+var requisiteRels = map[string]bool{
+	"stylesheet":       true,
+	"icon":             true,
+	"shortcut":         true,
+	"apple-touch-icon": true,
+	"mask-icon":        true,
+	"preload":          true,
+	"modulepreload":    true,
+	"prefetch":         true,
+	"manifest":         true,
+}
+
+// ExtractHTMLLinks returns the links in doc in document order, along with
+// the first <base href>, which the caller resolves the links against. The
+// sizes attribute holds only media conditions, so srcset is all that is read
+// from responsive images.
+func ExtractHTMLLinks(doc *html.Node) (base string, links []Link) {
+	RewriteHTMLLinks(doc, func(link string, page bool) string {
+		links = append(links, Link{URL: link, Page: page})
+		return link
+	})
+	return findBase(doc), links
+}
+
+// RewriteHTMLLinks calls rewrite for every link in doc that can be fetched,
+// in document order, and puts what it returns in the link's place.
+func RewriteHTMLLinks(doc *html.Node, rewrite func(link string, page bool) string) {
+	rewriteCSS := func(css string) string {
+		return RewriteCSSLinks(css, func(link string) string { return rewrite(link, false) })
+	}
+	rewriteAttr := func(n *html.Node, key string, page bool) {
+		for i, a := range n.Attr {
+			if a.Key != key {
+				continue
+			}
+			if link := strings.TrimSpace(a.Val); linkable(link) {
+				n.Attr[i].Val = rewrite(link, page)
+			}
+		}
+	}
+	rewriteSrcset := func(n *html.Node) {
+		for i, a := range n.Attr {
+			if a.Key == "srcset" {
+				n.Attr[i].Val = RewriteSrcset(a.Val, func(link string) string { return rewrite(link, false) })
+			}
+		}
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "a", "area":
+				rewriteAttr(n, "href", true)
+			case "iframe", "frame":
+				rewriteAttr(n, "src", true)
+			case "link":
+				rewriteAttr(n, "href", !isRequisiteLink(attrVal(n, "rel")))
+			case "meta":
+				if strings.EqualFold(attrVal(n, "http-equiv"), "refresh") {
+					rewriteRefresh(n, rewrite)
+				}
+			case "img", "source":
+				rewriteAttr(n, "src", false)
+				rewriteSrcset(n)
+			case "video":
+				rewriteAttr(n, "src", false)
+				rewriteAttr(n, "poster", false)
+			case "script", "audio", "track", "embed":
+				rewriteAttr(n, "src", false)
+			case "input":
+				if strings.EqualFold(attrVal(n, "type"), "image") {
+					rewriteAttr(n, "src", false)
+				}
+			case "object":
+				rewriteAttr(n, "data", false)
+			case "body", "table", "td", "th":
+				rewriteAttr(n, "background", false)
+			case "style":
+				if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+					n.FirstChild.Data = rewriteCSS(n.FirstChild.Data)
+				}
+			}
+			for i, a := range n.Attr {
+				if a.Key == "style" {
+					n.Attr[i].Val = rewriteCSS(a.Val)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+}
+
+// findBase returns the href of the first <base> element in doc, or "".
+func findBase(doc *html.Node) string {
+	if doc.Type == html.ElementNode && doc.Data == "base" {
+		if href, ok := attr(doc, "href"); ok {
+			return strings.TrimSpace(href)
+		}
+	}
+	for c := doc.FirstChild; c != nil; c = c.NextSibling {
+		if base := findBase(c); base != "" {
+			return base
+		}
+	}
+	return ""
+}
+
+// Matches url(...) with or without quotes and @import with a bare string.
+// Comments are matched too so the links inside them are passed over.
+var cssURLPattern = regexp.MustCompile(`(?s)/\*.*?\*/|url\(\s*(?:"([^"]*)"|'([^']*)'|([^\s'"()]*))\s*\)|@import\s+(?:"([^"]*)"|'([^']*)')`)
+
+// ExtractCSSLinks returns the url() and @import references in a stylesheet,
+// a <style> element or a style attribute, in the order they appear.
+func ExtractCSSLinks(css string) []string {
+	var links []string
+	RewriteCSSLinks(css, func(link string) string {
+		links = append(links, link)
+		return link
+	})
+	return links
+}
+
+// RewriteCSSLinks calls rewrite for every url() and @import reference in
+// css that can be fetched and puts what it returns in its place, keeping
+// any quotes around it.
+func RewriteCSSLinks(css string, rewrite func(link string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range cssURLPattern.FindAllStringSubmatchIndex(css, -1) {
+		for group := 1; group < len(match)/2; group++ {
+			start, end := match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+			if link := strings.TrimSpace(css[start:end]); linkable(link) {
+				b.WriteString(css[last:start])
+				b.WriteString(rewrite(link))
+				last = end
+			}
+			break
+		}
+	}
+	b.WriteString(css[last:])
+	return b.String()
+}
+
+// ParseSrcset returns the URLs of the image candidates in a srcset
+// attribute, such as "a.png 1x, b.png 2x".
+func ParseSrcset(srcset string) []string {
+	var links []string
+	RewriteSrcset(srcset, func(link string) string {
+		links = append(links, link)
+		return link
+	})
+	return links
+}
+
+// RewriteSrcset calls rewrite for the URL of every image candidate in a
+// srcset attribute and puts what it returns in its place, keeping the
+// descriptors. URLs may themselves contain commas, so candidates are split
+// on whitespace first, as browsers do.
+func RewriteSrcset(srcset string, rewrite func(link string) string) string {
+	const space = " \t\n\r\f"
+	var b strings.Builder
+	rest := srcset
+	for rest != "" {
+		link := strings.TrimLeft(rest, space+",")
+		b.WriteString(rest[:len(rest)-len(link)])
+		if link == "" {
+			break
+		}
+		rest = ""
+		if end := strings.IndexAny(link, space); end >= 0 {
+			link, rest = link[:end], link[end:]
+		}
+		// A trailing comma ends the candidate when no descriptors follow
+		trimmed := strings.TrimRight(link, ",")
+		if linkable(trimmed) {
+			b.WriteString(rewrite(trimmed))
+		} else {
+			b.WriteString(trimmed)
+		}
+		b.WriteString(link[len(trimmed):])
+		if len(trimmed) == len(link) {
+			descriptors := rest
+			if comma := strings.IndexByte(rest, ','); comma >= 0 {
+				descriptors = rest[:comma+1]
+			}
+			b.WriteString(descriptors)
+			rest = rest[len(descriptors):]
+		}
+	}
+	return b.String()
+}
+
+// rewriteRefresh rewrites the URL in the content of a
+// <meta http-equiv=refresh> element.
+func rewriteRefresh(n *html.Node, rewrite func(link string, page bool) string) {
+	for i, a := range n.Attr {
+		if a.Key != "content" {
+			continue
+		}
+		link := refreshURL(a.Val)
+		if !linkable(link) {
+			continue
+		}
+		at := strings.LastIndex(a.Val, link)
+		n.Attr[i].Val = a.Val[:at] + rewrite(link, true) + a.Val[at+len(link):]
+	}
+}
+
+// refreshURL returns the URL of a <meta http-equiv=refresh> content value
+// such as "5; url=/next.html", or "" when it only reloads the page.
+func refreshURL(content string) string {
+	_, after, ok := strings.Cut(content, ";")
+	if !ok {
+		_, after, ok = strings.Cut(content, ",")
+		if !ok {
+			return ""
+		}
+	}
+	after = strings.TrimSpace(after)
+	if len(after) >= 3 && strings.EqualFold(after[:3], "url") {
+		after = strings.TrimSpace(after[3:])
+		after = strings.TrimSpace(strings.TrimPrefix(after, "="))
+	}
+	return strings.Trim(after, `'"`)
+}
+
+// isRequisiteLink reports whether a <link rel> value names a page requisite.
+func isRequisiteLink(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if requisiteRels[r] {
+			return true
+		}
+	}
+	return false
+}
+
+// linkable reports whether link can be fetched over HTTP, skipping empty
+// links, fragments and schemes such as data:, mailto: and javascript:.
+func linkable(link string) bool {
+	if link == "" || strings.HasPrefix(link, "#") {
+		return false
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "" || scheme == "http" || scheme == "https"
+}
+
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func attrVal(n *html.Node, key string) string {
+	val, _ := attr(n, key)
+	return val
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// typeExtensions lists, for each media type with a well known extension,
+// the extensions a file of that type may already carry. The first one is
+// added when the file name has none of them.
+var typeExtensions = map[string][]string{
+	"text/html":              {".html", ".htm"},
+	"application/xhtml+xml":  {".html", ".xhtml", ".htm"},
+	"text/css":               {".css"},
+	"text/javascript":        {".js", ".mjs"},
+	"application/javascript": {".js", ".mjs"},
+	"application/json":       {".json"},
+	"application/xml":        {".xml"},
+	"text/xml":               {".xml"},
+	"text/plain":             {".txt"},
+	"image/svg+xml":          {".svg"},
+	"image/png":              {".png"},
+	"image/jpeg":             {".jpg", ".jpeg"},
+	"image/gif":              {".gif"},
+	"image/webp":             {".webp"},
+	"application/pdf":        {".pdf"},
+}
+
+// QuerySeparator stands in for the "?" of a URL in local file names, as in
+// wget's Windows file names, since "?" cannot be used everywhere.
+const QuerySeparator = "@"
+
+// LocalFileName returns the name a mirror saves the file at u under, inside
+// the directory mirroring the URL path. A directory URL is saved as
+// index.html and a query string is kept after QuerySeparator, so
+// "page?id=3" and "page?id=4" do not overwrite each other.
+func LocalFileName(u *url.URL) string {
+	name := path.Base(u.Path)
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") || name == "." || name == ".." {
+		name = "index.html"
+	}
+	if u.RawQuery != "" {
+		// The query may hold slashes, which would start a directory
+		name += QuerySeparator + strings.ReplaceAll(u.RawQuery, "/", "%2F")
+	}
+	return name
+}
+
+// AdjustExtension appends the extension for the media type of contentType
+// to name, unless name already ends in one for that type or the type has no
+// known extension.
+func AdjustExtension(name, contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return name
+	}
+	extensions := typeExtensions[mediaType]
+	if len(extensions) == 0 {
+		return name
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, known := range extensions {
+		if ext == known {
+			return name
+		}
+	}
+	return name + extensions[0]
+}
+
+// AdjustedNames returns name followed by every name AdjustExtension can
+// turn it into.
+func AdjustedNames(name string) []string {
+	var adjusted []string
+	for mediaType := range typeExtensions {
+		if n := AdjustExtension(name, mediaType); n != name && !slices.Contains(adjusted, n) {
+			adjusted = append(adjusted, n)
+		}
+	}
+	sort.Strings(adjusted)
+	return append([]string{name}, adjusted...)
+}
+
+// AddedExtension reports the extension AdjustExtension may have appended to
+// name, or "" when its extension is not one that AdjustExtension adds.
+func AddedExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	for _, extensions := range typeExtensions {
+		if extensions[0] == ext {
+			return path.Ext(name)
+		}
+	}
+	return ""
+}
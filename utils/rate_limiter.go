@@ -5,11 +5,19 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type RateLimitedReader struct {
-	reader     io.Reader
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+// RateLimiter is a token bucket that can be shared by several readers so
+// that their combined throughput stays under a single limit.
+type RateLimiter struct {
+	mu         sync.Mutex
 	rateLimit  int64 // bytes per second
 	bucket     int64
 	lastFilled time.Time
@@ -47,7 +55,9 @@ func RateLimitValidator(s string) error {
 	return nil
 }
 
-func parseRateLimit(rateLimit string) (int64, error) {
+// ParseRateLimit converts a --rate-limit value such as "400k" or "2M" to
+// bytes per second. A value without a unit is taken as bytes.
+func ParseRateLimit(rateLimit string) (int64, error) {
 	if len(rateLimit) < 2 {
 		return 0, fmt.Errorf("invalid rate limit")
 	}
@@ -57,7 +67,7 @@ func parseRateLimit(rateLimit string) (int64, error) {
 	case 'k', 'K':
 		multiplier = 1024
 		rateLimit = rateLimit[:len(rateLimit)-1]
-	case 'M':
+	case 'm', 'M':
 		multiplier = 1024 * 1024
 		rateLimit = rateLimit[:len(rateLimit)-1]
 	}
@@ -69,25 +79,52 @@ func parseRateLimit(rateLimit string) (int64, error) {
 	return int64(rate * multiplier), nil
 }
 func NewRateLimitedReader(reader io.Reader, limit string) *RateLimitedReader {
+	return NewSharedRateLimitedReader(reader, NewRateLimiter(limit))
+}
+
+// NewSharedRateLimitedReader wraps reader so that it draws from limiter,
+// which may be shared with other readers.
+func NewSharedRateLimitedReader(reader io.Reader, limiter *RateLimiter) *RateLimitedReader {
+	return &RateLimitedReader{reader: reader, limiter: limiter}
+}
+
+// NewRateLimiter creates a limiter from a --rate-limit value such as "400k".
+func NewRateLimiter(limit string) *RateLimiter {
 	// Convert limit to bytes per second (rateLimit)
-	rateLimit, _ := parseRateLimit(limit)
-	return &RateLimitedReader{reader: reader, rateLimit: rateLimit, lastFilled: time.Now()}
+	rateLimit, _ := ParseRateLimit(limit)
+	return &RateLimiter{rateLimit: rateLimit, lastFilled: time.Now()}
 }
 
-func (r *RateLimitedReader) Read(p []byte) (n int, err error) {
-	if r.bucket <= 0 {
+// take blocks until the bucket has tokens and reserves up to n of them.
+func (l *RateLimiter) take(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.bucket <= 0 {
 		time.Sleep(time.Second)
-		r.bucket = r.rateLimit
-		r.lastFilled = time.Now()
+		l.bucket = l.rateLimit
+		l.lastFilled = time.Now()
 	}
 
-	toRead := int64(len(p))
-	if toRead > r.bucket {
-		toRead = r.bucket
+	if n > l.bucket {
+		n = l.bucket
 	}
+	l.bucket -= n
+	return n
+}
+
+// refund returns reserved tokens that a short read did not use.
+func (l *RateLimiter) refund(n int64) {
+	l.mu.Lock()
+	l.bucket += n
+	l.mu.Unlock()
+}
+
+func (r *RateLimitedReader) Read(p []byte) (n int, err error) {
+	toRead := r.limiter.take(int64(len(p)))
 
 	n, err = r.reader.Read(p[:toRead])
-	r.bucket -= int64(n)
+	r.limiter.refund(toRead - int64(n))
 
 	return n, err
 }
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultUserAgent identifies the tool honestly unless --user-agent says
+// otherwise.
+const DefaultUserAgent = "Wget/1.21 (go-wget)"
+
+// RequestOptions holds the user supplied settings applied to every request
+// the tool makes, mirror asset fetches included.
+type RequestOptions struct {
+	Method    string      // --method; empty means GET, or POST when a body is set
+	Headers   http.Header // --header, repeatable
+	UserAgent string      // --user-agent
+	Referer   string      // --referer
+	Body      []byte      // --body-data or the contents of --body-file
+
+	// Credentials are only filled in for requests to a host the user named;
+	// see AppState.requestOptions
+	Credentials Credentials
+}
+
+// AddHeader parses a "Name: value" pair as given to --header. An empty value
+// removes the header from requests, including the default ones.
+func (o *RequestOptions) AddHeader(header string) error {
+	name, value, found := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid header '%s', expected \"Name: value\"", header)
+	}
+	if o.Headers == nil {
+		o.Headers = make(http.Header)
+	}
+	o.Headers.Add(name, strings.TrimSpace(value))
+	return nil
+}
+
+func (o RequestOptions) method() string {
+	switch {
+	case o.Method != "":
+		return o.Method
+	case len(o.Body) > 0:
+		return "POST"
+	default:
+		return "GET"
+	}
+}
+
+// apply sets the default headers on req and then the user supplied ones,
+// which replace any default of the same name.
+func (o RequestOptions) apply(req *http.Request) {
+	userAgent := DefaultUserAgent
+	if o.UserAgent != "" {
+		userAgent = o.UserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "*/*")
+	if o.Referer != "" {
+		req.Header.Set("Referer", o.Referer)
+	}
+	if o.Credentials.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+o.Credentials.BearerToken)
+	} else if o.Credentials.User != "" || o.Credentials.Password != "" {
+		req.SetBasicAuth(o.Credentials.User, o.Credentials.Password)
+	}
+	if len(o.Body) > 0 && req.Body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	for name, values := range o.Headers {
+		name = http.CanonicalHeaderKey(name)
+		req.Header.Del(name)
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			if name == "Host" {
+				req.Host = value
+				continue
+			}
+			req.Header.Add(name, value)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ResolveURL resolves a link found on the document at base as described in
+// RFC 3986, so "img/a.png" lands in the document's directory and "../"
+// climbs out of it. The fragment is dropped since it never changes what is
+// fetched. It returns "" when either URL cannot be parsed.
+func ResolveURL(base, rel string) string {
+	baseURL, err := url.Parse(strings.TrimSpace(base))
+	if err != nil {
+		return ""
+	}
+	relURL, err := url.Parse(strings.TrimSpace(rel))
+	if err != nil {
+		return ""
+	}
+	resolved := baseURL.ResolveReference(relURL)
+	resolved.Fragment, resolved.RawFragment = "", ""
+	return resolved.String()
+}
+
+// CanonicalURL rewrites rawURL into one canonical spelling so the same
+// resource is recognised however a page links to it. The scheme and host
+// are lowercased, the default port is removed, an empty path becomes "/",
+// dot segments and the fragment are dropped, escapes of unreserved
+// characters are decoded and the rest use upper case hex. Query parameters
+// whose names match one of stripParams, as names or globs, are removed. A
+// URL that cannot be parsed is returned unchanged.
+func CanonicalURL(rawURL string, stripParams []string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port == "80" && u.Scheme == "http" || port == "443" && u.Scheme == "https" {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+	u.Fragment, u.RawFragment = "", ""
+
+	// Resolving an empty reference removes "." and ".." segments
+	u = u.ResolveReference(&url.URL{})
+	escaped := normalizeEscapes(u.EscapedPath())
+	if escaped == "" {
+		escaped = "/"
+	}
+	if unescaped, err := url.PathUnescape(escaped); err == nil {
+		u.Path, u.RawPath = unescaped, escaped
+	}
+
+	u.RawQuery = stripQuery(normalizeEscapes(u.RawQuery), stripParams)
+	u.ForceQuery = false
+	return u.String()
+}
+
+// normalizeEscapes decodes percent escapes of unreserved characters and
+// upper cases the hex digits of the others, the normalisations RFC 3986
+// section 6.2.2 allows.
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// stripQuery removes the parameters named in stripParams from a raw query,
+// keeping the others in their original order.
+func stripQuery(rawQuery string, stripParams []string) string {
+	if rawQuery == "" || len(stripParams) == 0 {
+		return rawQuery
+	}
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !paramMatches(name, stripParams) {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+func paramMatches(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether a failed request is worth repeating and how
+// long to wait before doing so.
+type RetryPolicy struct {
+	Tries     int           // total attempts, including the first one
+	WaitRetry time.Duration // upper bound for the backoff between attempts
+	RetryOn   map[int]bool  // extra status codes to retry besides 408, 429 and 5xx
+}
+
+// DefaultRetryPolicy is used when no retry flags are given.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{Tries: 3, WaitRetry: 10 * time.Second}
+}
+
+// ParseStatusList parses a comma separated list of HTTP status codes such as
+// "503,429" as given to --retry-on-http-error.
+func ParseStatusList(list string) (map[int]bool, error) {
+	codes := make(map[int]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code '%s'", field)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// Do calls send until it succeeds with a status that is not worth retrying,
+// fails with an error that is not worth retrying, or the policy runs out of
+// tries. The last response or error is returned to the caller unchanged.
+func (p RetryPolicy) Do(ctx context.Context, send func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := send()
+		if attempt >= p.Tries || ctx.Err() != nil {
+			return resp, err
+		}
+
+		var retryAfter time.Duration
+		switch {
+		case err != nil:
+			if !IsRetryableError(err) {
+				return nil, err
+			}
+			fmt.Printf("%v\n", err)
+		case p.IsRetryableStatus(resp.StatusCode):
+			retryAfter = RetryAfter(resp)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			fmt.Printf("server replied %s\n", resp.Status)
+		default:
+			return resp, nil
+		}
+
+		wait := p.Backoff(attempt)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		fmt.Printf("retrying in %s (attempt %d of %d)\n", wait.Round(time.Millisecond), attempt+1, p.Tries)
+		if err := Sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Sleep waits for d or until ctx is cancelled, whichever comes first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Backoff returns the delay before the attempt following attempt: an
+// exponentially growing window capped at WaitRetry, with full jitter so that
+// concurrent downloads do not retry in lockstep.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if p.WaitRetry <= 0 {
+		return 0
+	}
+	window := time.Second << min(attempt-1, 16)
+	if window > p.WaitRetry {
+		window = p.WaitRetry
+	}
+	return time.Duration(rand.Int63n(int64(window) + 1))
+}
+
+// IsRetryableStatus reports whether a response with the given status code
+// should be requested again.
+func (p RetryPolicy) IsRetryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests ||
+		code >= 500 ||
+		p.RetryOn[code]
+}
+
+// IsRetryableError reports whether err is a transient network failure such as
+// a timeout, a reset connection or a transfer cut short.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	return false
+}
+
+// RetryAfter returns the delay requested by a Retry-After header, given either
+// as a number of seconds or as an HTTP date, or 0 when there is none.
+func RetryAfter(resp *http.Response) time.Duration {
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RobotsAgent is the product token matched against User-agent lines.
+const RobotsAgent = "wget"
+
+// Robots holds the rules of a robots.txt file.
+type Robots struct {
+	groups   []*robotsGroup
+	Sitemaps []string
+}
+
+// robotsGroup is a set of rules shared by one or more user agents.
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// AllowAll and DisallowAll stand in for robots.txt files that are missing or
+// could not be fetched.
+var (
+	AllowAll    = &Robots{}
+	DisallowAll = &Robots{groups: []*robotsGroup{{agents: []string{"*"}, rules: []robotsRule{{pattern: "/"}}}}}
+)
+
+// ParseRobots reads a robots.txt file. Unknown lines are ignored and a
+// group starts at each run of User-agent lines, as described in RFC 9309.
+func ParseRobots(r io.Reader) *Robots {
+	robots := &Robots{}
+	var group *robotsGroup
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				group = &robotsGroup{}
+				robots.groups = append(robots.groups, group)
+				inAgents = true
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+			continue
+		case "allow", "disallow":
+			// An empty Disallow allows everything and adds no rule
+			if group != nil && value != "" {
+				group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if seconds, err := strconv.ParseFloat(value, 64); group != nil && err == nil && seconds > 0 {
+				group.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			robots.Sitemaps = append(robots.Sitemaps, value)
+		}
+		inAgents = false
+	}
+	return robots
+}
+
+// rules returns the groups that apply to agent: every group naming it, or
+// the * groups when none does.
+func (r *Robots) rules(agent string) []*robotsGroup {
+	agent = strings.ToLower(agent)
+	var named, wildcard []*robotsGroup
+	for _, group := range r.groups {
+		for _, name := range group.agents {
+			if name == "*" {
+				wildcard = append(wildcard, group)
+				break
+			}
+			if name != "" && strings.HasPrefix(agent, name) {
+				named = append(named, group)
+				break
+			}
+		}
+	}
+	if len(named) > 0 {
+		return named
+	}
+	return wildcard
+}
+
+// Allowed reports whether agent may fetch rawURL. The longest matching rule
+// wins, and Allow wins a tie with Disallow.
+func (r *Robots) Allowed(agent, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allowed, longest := true, -1
+	for _, group := range r.rules(agent) {
+		for _, rule := range group.rules {
+			if !robotsMatch(rule.pattern, path) {
+				continue
+			}
+			if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+				allowed, longest = rule.allow, n
+			}
+		}
+	}
+	return allowed
+}
+
+// CrawlDelay returns the delay agent should leave between requests, or 0.
+func (r *Robots) CrawlDelay(agent string) time.Duration {
+	var delay time.Duration
+	for _, group := range r.rules(agent) {
+		delay = max(delay, group.crawlDelay)
+	}
+	return delay
+}
+
+// robotsMatch matches path against a rule where * matches any sequence of
+// characters and a trailing $ anchors the end of the path.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	parts := strings.Split(strings.TrimSuffix(pattern, "$"), "*")
+	if anchored {
+		// The last part has to end the path; match the rest before it
+		last := parts[len(parts)-1]
+		if len(parts) == 1 {
+			return path == last
+		}
+		if !strings.HasSuffix(path, last) {
+			return false
+		}
+		path, parts = path[:len(path)-len(last)], parts[:len(parts)-1]
+	}
+
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for _, part := range parts[1:] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return true
+}
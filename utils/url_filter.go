@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// URLFilter decides which files a mirror keeps, following wget's accept and
+// reject rules. Empty lists and nil patterns let everything through.
+type URLFilter struct {
+	Accept      []string // --accept: file name suffixes or globs
+	Reject      []string // --reject: file name suffixes or globs
+	AcceptRegex *regexp.Regexp
+	RejectRegex *regexp.Regexp
+	IncludeDirs []string // --include-directories: directory prefixes or globs
+	ExcludeDirs []string // --exclude-directories: directory prefixes or globs
+	AcceptTypes []string // --accept-type: MIME types such as text/css or image/*
+	RejectTypes []string // --reject-type
+}
+
+// SplitList splits a comma separated flag value, dropping empty entries.
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// AllowName applies the accept and reject lists and regexes to rawURL. The
+// lists match the file name, ignoring any query string, so "?v=1" does not
+// defeat a rule; the regexes match the whole URL.
+func (f *URLFilter) AllowName(rawURL string) bool {
+	name := path.Base(urlPath(rawURL))
+	if len(f.Accept) > 0 && !matchesAny(name, f.Accept) {
+		return false
+	}
+	if matchesAny(name, f.Reject) {
+		return false
+	}
+	return f.AllowRegex(rawURL)
+}
+
+// AllowRegex applies only the accept and reject regexes to rawURL.
+func (f *URLFilter) AllowRegex(rawURL string) bool {
+	if f.AcceptRegex != nil && !f.AcceptRegex.MatchString(rawURL) {
+		return false
+	}
+	return f.RejectRegex == nil || !f.RejectRegex.MatchString(rawURL)
+}
+
+// LooksLikeHTML reports whether rawURL names a directory, a file without an
+// extension or an .html file, the pages a crawl fetches to find more links
+// even when the accept and reject lists would not keep them.
+func LooksLikeHTML(rawURL string) bool {
+	p := urlPath(rawURL)
+	if strings.HasSuffix(p, "/") {
+		return true
+	}
+	switch strings.ToLower(path.Ext(p)) {
+	case "", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// AllowDir applies the include and exclude directory lists to the directory
+// of rawURL.
+func (f *URLFilter) AllowDir(rawURL string) bool {
+	dir := path.Dir(urlPath(rawURL))
+	if len(f.IncludeDirs) > 0 && !dirMatchesAny(dir, f.IncludeDirs) {
+		return false
+	}
+	return !dirMatchesAny(dir, f.ExcludeDirs)
+}
+
+// AllowType applies the accept and reject MIME type lists to a Content-Type
+// header. A missing header is let through.
+func (f *URLFilter) AllowType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	if len(f.AcceptTypes) > 0 && !typeMatchesAny(mediaType, f.AcceptTypes) {
+		return false
+	}
+	return !typeMatchesAny(mediaType, f.RejectTypes)
+}
+
+// urlPath returns the unescaped path of rawURL without query or fragment.
+func urlPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// matchesAny matches a file name against suffixes, or globs when the
+// pattern has wildcards, case insensitively.
+func matchesAny(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if isGlob(pattern) {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		} else if strings.HasSuffix(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirMatchesAny reports whether dir is, or lies below, one of dirs. Globs
+// are matched against as many leading components of dir as they have.
+func dirMatchesAny(dir string, dirs []string) bool {
+	for _, pattern := range dirs {
+		pattern = "/" + strings.Trim(pattern, "/")
+		if pattern == "/" {
+			return true
+		}
+		if isGlob(pattern) {
+			depth := strings.Count(pattern, "/")
+			parts := strings.SplitAfterN(dir, "/", depth+2)
+			if len(parts) > depth {
+				prefix := strings.TrimSuffix(strings.Join(parts[:depth+1], ""), "/")
+				if ok, _ := path.Match(pattern, prefix); ok {
+					return true
+				}
+			}
+		} else if dir == pattern || strings.HasPrefix(dir, pattern+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeMatchesAny matches a media type against types such as "text/css" or
+// "image/*".
+func typeMatchesAny(mediaType string, types []string) bool {
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}